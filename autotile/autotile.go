@@ -0,0 +1,176 @@
+// Package autotile picks the edge and corner tiles of terrain like grass,
+// hills and tilled soil from the tileset codes of the neighbouring tiles,
+// so maps only need to say where the terrain is.
+package autotile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	"main/sim"
+)
+
+// Sides of a tile, a mask of the sides that join the same terrain picks the
+// tile of a Rule
+const (
+	North = 1
+	East  = 2
+	South = 4
+	West  = 8
+
+	allSides = North | East | South | West
+)
+
+// Rule is the autotile section of a tileset in tilesets.json.
+type Rule struct {
+	// Codes of neighbours that count as the same terrain, the tileset's own
+	// code always does
+	Joins []string `json:"joins"`
+
+	// Tile by the mask of joined sides. Tiles with all sides joined keep
+	// their index, so variants like grass with flowers stay. Edge and
+	// corner tiles that end up with all sides joined get the tile of mask
+	// 15 if there is one.
+	Edges map[int]int `json:"edges"`
+
+	// Tile by the missing diagonal ("ne", "se", "sw" or "nw") of a tile
+	// with all sides joined
+	Corners map[string]int `json:"corners"`
+}
+
+// Rules are the autotile rules by tileset code.
+type Rules map[string]*Rule
+
+// Load reads the autotile rules of the tilesets in a tilesets.json file.
+func Load(path string) (Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tilesets map[string]struct {
+		Autotile *Rule `json:"autotile"`
+	}
+	if err := json.Unmarshal(data, &tilesets); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	rules := make(Rules)
+	for code, tileset := range tilesets {
+		if tileset.Autotile == nil {
+			continue
+		}
+		for mask := range tileset.Autotile.Edges {
+			if mask < 0 || mask > allSides {
+				return nil, fmt.Errorf("%s: autotile of %s has edges for mask %d, masks go from 0 to %d", path, code, mask, allSides)
+			}
+		}
+		for corner := range tileset.Autotile.Corners {
+			if !slices.ContainsFunc(diagonals, func(d diagonal) bool { return d.corner == corner }) {
+				return nil, fmt.Errorf("%s: autotile of %s has unknown corner %q", path, code, corner)
+			}
+		}
+		rules[code] = tileset.Autotile
+	}
+	return rules, nil
+}
+
+// diagonal is the neighbour of a tile at one of its corners.
+type diagonal struct {
+	corner   string
+	col, row int
+}
+
+var diagonals = []diagonal{
+	{"ne", 1, -1},
+	{"se", 1, 1},
+	{"sw", -1, 1},
+	{"nw", -1, -1},
+}
+
+// shaped reports whether a tile is one of the edge or corner tiles.
+func (rule *Rule) shaped(tile int) bool {
+	for mask, edge := range rule.Edges {
+		if edge == tile && mask != allSides {
+			return true
+		}
+	}
+	for _, corner := range rule.Corners {
+		if corner == tile {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply changes the tiles of a map that have a rule to fit their
+// neighbours. Tiles are stored row by row. The land outside the map counts
+// as joined so maps don't get edges along their border.
+func (r Rules) Apply(w, h int, tiles []int, codes []string) {
+	if len(tiles) < w*h || len(codes) < w*h {
+		return
+	}
+	joins := func(rule *Rule, code string, col, row int) bool {
+		if col < 0 || row < 0 || col >= w || row >= h {
+			return true
+		}
+		other := codes[row*w+col]
+		return other == code || slices.Contains(rule.Joins, other)
+	}
+	for row := 0; row < h; row++ {
+		for col := 0; col < w; col++ {
+			i := row*w + col
+			rule := r[codes[i]]
+			if rule == nil {
+				continue
+			}
+			mask := 0
+			if joins(rule, codes[i], col, row-1) {
+				mask |= North
+			}
+			if joins(rule, codes[i], col+1, row) {
+				mask |= East
+			}
+			if joins(rule, codes[i], col, row+1) {
+				mask |= South
+			}
+			if joins(rule, codes[i], col-1, row) {
+				mask |= West
+			}
+			if mask != allSides {
+				if tile, ok := rule.Edges[mask]; ok {
+					tiles[i] = tile
+				}
+				continue
+			}
+			corner := ""
+			for _, d := range diagonals {
+				if !joins(rule, codes[i], col+d.col, row+d.row) {
+					corner = d.corner
+					break
+				}
+			}
+			if tile, ok := rule.Corners[corner]; ok {
+				tiles[i] = tile
+			} else if tile, ok := rule.Edges[allSides]; ok && rule.shaped(tiles[i]) {
+				tiles[i] = tile
+			}
+		}
+	}
+}
+
+// ApplyFields autotiles the map in the fields of a map file, see
+// sim.ParseMap. Fields that aren't a whole map come back unchanged.
+func (r Rules) ApplyFields(fields []string) []string {
+	m := sim.ParseMap(fields)
+	if len(r) == 0 || len(m.Tiles) != m.W*m.H || len(m.Codes) < m.W*m.H {
+		return fields
+	}
+	r.Apply(m.W, m.H, m.Tiles, m.Codes)
+	tiled := []string{strconv.Itoa(m.W), strconv.Itoa(m.H)}
+	for _, tile := range m.Tiles {
+		tiled = append(tiled, strconv.Itoa(tile))
+	}
+	return append(tiled, m.Codes...)
+}
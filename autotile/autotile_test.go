@@ -0,0 +1,87 @@
+package autotile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+var testRules = Rules{
+	"h": {
+		Joins: []string{"x"},
+		Edges: map[int]int{
+			0:                   100,
+			North | South:       105,
+			East | West:         110,
+			East | South | West: 114,
+			allSides:            200,
+		},
+		Corners: map[string]int{"ne": 301, "se": 302, "sw": 303, "nw": 304},
+	},
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes string // 3x3 tileset codes, the middle tile is checked
+		tile  int    // tile of the middle before autotiling
+		want  int
+	}{
+		{"alone", "g g g g h g g g g", 1, 100},
+		{"column", "g h g g h g g h g", 1, 105},
+		{"row", "g g g h h h g g g", 1, 110},
+		{"open to the north", "g g g h h h h h h", 1, 114},
+		{"joined code", "g x g g h g g h g", 1, 105},
+		{"mask without an edge keeps the tile", "g h g g h g g g g", 1, 1},
+		{"all joined keeps a variant", "h h h h h h h h h", 3, 3},
+		{"all joined resets an edge", "h h h h h h h h h", 105, 200},
+		{"missing ne", "h h g h h h h h h", 1, 301},
+		{"missing se", "h h h h h h h h g", 1, 302},
+		{"missing sw", "h h h h h h g h h", 1, 303},
+		{"missing nw", "g h h h h h h h h", 1, 304},
+		{"first missing corner wins", "g h g h h h h h h", 1, 301},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codes := strings.Fields(test.codes)
+			tiles := make([]int, len(codes))
+			tiles[4] = test.tile
+			testRules.Apply(3, 3, tiles, codes)
+			if tiles[4] != test.want {
+				t.Fatalf("middle tile of %q = %d, want %d", test.codes, tiles[4], test.want)
+			}
+		})
+	}
+}
+
+func TestApplyBorderJoins(t *testing.T) {
+	// Outside the map counts as the same terrain
+	tiles := []int{1}
+	testRules.Apply(1, 1, tiles, []string{"h"})
+	if tiles[0] != 1 {
+		t.Fatalf("tile alone on a map = %d, want 1", tiles[0])
+	}
+}
+
+func TestApplyFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		rules  Rules
+		fields string
+		want   string
+	}{
+		{"autotiled", testRules, "3 1 1 1 1 g h g", "3 1 1 105 1 g h g"},
+		{"padded header", testRules, "003 001 01 01 01 g h g", "3 1 1 105 1 g h g"},
+		{"no rules", nil, "3 1 1 1 1 g h g", "3 1 1 1 1 g h g"},
+		{"missing codes", testRules, "3 1 1 1 1 g h", "3 1 1 1 1 g h"},
+		{"missing tiles", testRules, "3 1 1 1", "3 1 1 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.rules.ApplyFields(strings.Fields(test.fields))
+			if !slices.Equal(got, strings.Fields(test.want)) {
+				t.Fatalf("ApplyFields(%q) = %q, want %q", test.fields, strings.Join(got, " "), test.want)
+			}
+		})
+	}
+}
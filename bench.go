@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/mapgen"
+	"main/sim"
+)
+
+// The bench mode draws a large generated map while the camera moves across
+// it, once tile by tile like before the chunk textures and once with them.
+const (
+	benchMapSize = 256 // tiles across and down
+	benchFrames  = 120
+)
+
+// benchMap loads a generated map into the client, like hosting random:<seed>.
+func benchMap(seed int64) {
+	m, err := mapgen.Generate(mapgen.Options{
+		Seed:     seed,
+		W:        benchMapSize,
+		H:        benchMapSize,
+		Autotile: autotileRules,
+	})
+	if err != nil {
+		log.Fatal("Generating the bench map: ", err)
+	}
+	mapW, mapH = m.W, m.H
+	tileMap = m.Tiles
+	srcMap = m.Codes
+
+	chunkMap, mapVersion = "bench", 1
+	loadedChunks = make(map[sim.ChunkPos]bool)
+	cols, rows := currentMap().ChunkCount()
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			loadedChunks[sim.ChunkPos{x, y}] = true
+		}
+	}
+	dropChunkTextures()
+}
+
+// benchDraw returns the average time a frame takes with a way to draw the
+// map.
+func benchDraw(draw func(), cached bool) time.Duration {
+	size := float32(benchMapSize) * tileDest.Width
+	start := time.Now()
+	for frame := 0; frame < benchFrames; frame++ {
+		progress := float32(frame) / benchFrames
+		cam.Target = rl.NewVector2(size*progress, size*progress)
+		if cached {
+			cacheChunks()
+		}
+		rl.BeginDrawing()
+		rl.ClearBackground(bkgColor)
+		rl.BeginMode2D(cam)
+		draw()
+		rl.EndMode2D()
+		rl.EndDrawing()
+	}
+	return time.Since(start) / benchFrames
+}
+
+// drawEveryTile draws the whole map tile by tile.
+func drawEveryTile() {
+	m := currentMap()
+	for i := range m.Tiles {
+		if m.Tiles[i] != 0 {
+			rect := tileRect(m, i)
+			drawTile(m, i, rect.X, rect.Y)
+		}
+	}
+}
+
+// runBench prints how long drawing a frame of a large map takes.
+func runBench() {
+	rl.SetTargetFPS(0)
+	benchMap(1)
+	fmt.Printf("Drawing a %dx%d map, %d frames each\n", benchMapSize, benchMapSize, benchFrames)
+	fmt.Printf("  every tile:    %v per frame\n", benchDraw(drawEveryTile, false))
+	fmt.Printf("  chunk texture: %v per frame\n", benchDraw(func() {
+		drawMapTiles()
+		drawDepthSorted(tallSprites())
+	}, true))
+	fmt.Printf("  %d chunk textures in use\n", len(chunkTextures))
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+	"unicode"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+const (
+	// Lines kept in the chat log and shown at once
+	chatLogSize    = 100
+	chatLogVisible = 8
+
+	// Closed chat shows new lines for this long
+	chatShowTime = 10 * time.Second
+)
+
+type chatLine struct {
+	Name   string
+	Text   string
+	System bool
+	At     time.Time
+}
+
+var (
+	chatMutex  sync.Mutex
+	chatLog    []chatLine
+	chatOpen   bool
+	chatInput  string
+	chatScroll int
+
+	// Flood state of players on the host server
+	chatFloodMutex sync.Mutex
+	chatFlood      = make(sim.ChatFlood)
+)
+
+// filterChat checks a message of a player before it is sent to everyone.
+func filterChat(playerID string, text string) (string, error) {
+	chatFloodMutex.Lock()
+	defer chatFloodMutex.Unlock()
+	return chatFlood.Filter(playerID, text)
+}
+
+func forgetChatFlood(playerID string) {
+	chatFloodMutex.Lock()
+	delete(chatFlood, playerID)
+	chatFloodMutex.Unlock()
+}
+
+// handleChatWS filters a chat message and hands it to broadcast. Rejected
+// messages are answered to the sender only.
+func handleChatWS(data map[string]string, conn MessageWriter, broadcast func([]byte)) {
+	playerID := data["player_id"]
+	text, err := filterChat(playerID, data["text"])
+	if err != nil {
+		response, _ := json.Marshal(map[string]string{
+			"type":      "chat_error",
+			"error":     err.Error(),
+			"player_id": playerID,
+		})
+		conn.WriteMessage(websocket.TextMessage, response)
+		return
+	}
+
+	playersMutex.RLock()
+	name := playerNames[playerID]
+	playersMutex.RUnlock()
+
+	// The gateway sends messages marked broadcast to all players of the
+	// lobby, player_id is the sender
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":      "chat",
+		"broadcast": true,
+		"player_id": playerID,
+		"name":      name,
+		"text":      text,
+	})
+	if err != nil {
+		log.Println("Error marshalling chat message:", err)
+		return
+	}
+	broadcast(msg)
+}
+
+func sendChatWS(text string) {
+	if websocket_client == nil {
+		return
+	}
+	msg, err := json.Marshal(map[string]string{
+		"command":   "chat",
+		"text":      text,
+		"player_id": joinPlayerID,
+	})
+	if err != nil {
+		log.Println("Error marshalling chat message:", err)
+		return
+	}
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending chat message:", err)
+	}
+}
+
+// addChatLine adds a line to the chat log, it is called from the network
+// goroutine.
+func addChatLine(name string, text string, system bool) {
+	chatMutex.Lock()
+	chatLog = append(chatLog, chatLine{Name: name, Text: text, System: system, At: time.Now()})
+	if len(chatLog) > chatLogSize {
+		chatLog = chatLog[len(chatLog)-chatLogSize:]
+	}
+	chatMutex.Unlock()
+}
+
+// updateChat handles the chat input box, ActionChat opens it and sends the
+// message, Escape closes it.
+func updateChat() {
+	if settingsOpen || menuActive {
+		return
+	}
+	if !chatOpen {
+		if actionPressed(ActionChat) {
+			chatOpen = true
+			chatInput = ""
+			chatScroll = 0
+			// Don't type the key that opened the chat
+			for rl.GetCharPressed() != 0 {
+			}
+		}
+		return
+	}
+
+	if rl.IsKeyPressed(rl.KeyEscape) {
+		chatOpen = false
+		return
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) {
+		if text := sim.CleanChat(chatInput); text != "" {
+			sendChatWS(text)
+		}
+		chatOpen = false
+		return
+	}
+	for c := rl.GetCharPressed(); c != 0; c = rl.GetCharPressed() {
+		if unicode.IsPrint(rune(c)) && len([]rune(chatInput)) < sim.MaxChatLength {
+			chatInput += string(rune(c))
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && chatInput != "" {
+		runes := []rune(chatInput)
+		chatInput = string(runes[:len(runes)-1])
+	}
+
+	// Scroll back through the log
+	scroll := int(rl.GetMouseWheelMove())
+	if rl.IsKeyPressed(rl.KeyPageUp) {
+		scroll += chatLogVisible
+	}
+	if rl.IsKeyPressed(rl.KeyPageDown) {
+		scroll -= chatLogVisible
+	}
+	chatMutex.Lock()
+	maxScroll := len(chatLog) - chatLogVisible
+	chatMutex.Unlock()
+	chatScroll += scroll
+	if chatScroll > maxScroll {
+		chatScroll = maxScroll
+	}
+	if chatScroll < 0 {
+		chatScroll = 0
+	}
+}
+
+// drawChat draws the chat log at the bottom left, and the input box while
+// the chat is open.
+func drawChat() {
+	const fontSize = 20
+	const lineHeight = 24
+	// Above the hotbar
+	bottom := screenHeight - slotSize - 60
+
+	chatMutex.Lock()
+	end := len(chatLog) - chatScroll
+	start := end - chatLogVisible
+	if start < 0 {
+		start = 0
+	}
+	lines := append([]chatLine(nil), chatLog[start:end]...)
+	chatMutex.Unlock()
+
+	if chatOpen {
+		rl.DrawRectangle(10, bottom-chatLogVisible*lineHeight-6, 600, chatLogVisible*lineHeight+4, rl.Fade(rl.Black, 0.4))
+	}
+	for i, line := range lines {
+		alpha := float32(1)
+		if !chatOpen {
+			age := time.Since(line.At)
+			if age > chatShowTime {
+				continue
+			}
+			// Fade out during the last second
+			if remaining := chatShowTime - age; remaining < time.Second {
+				alpha = float32(remaining.Seconds())
+			}
+		}
+		text := line.Text
+		color := rl.White
+		if line.System {
+			color = rl.Yellow
+		} else {
+			text = line.Name + ": " + text
+		}
+		y := bottom - int32(len(lines)-i)*lineHeight
+		rl.DrawText(text, 17, y+1, fontSize, rl.Fade(rl.Black, alpha*0.6))
+		rl.DrawText(text, 16, y, fontSize, rl.Fade(color, alpha))
+	}
+
+	if chatOpen {
+		rl.DrawRectangle(10, bottom+4, 600, 28, rl.Fade(rl.Black, 0.6))
+		input := chatInput
+		if int(rl.GetTime()*2)%2 == 0 {
+			input += "_"
+		}
+		// Keep the end of long messages visible
+		for rl.MeasureText(input, fontSize) > 580 {
+			input = string([]rune(input)[1:])
+		}
+		rl.DrawText(input, 16, bottom+8, fontSize, rl.White)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+// Chunks loaded ahead around the visible ones, and how far away from the
+// visible ones chunks are kept before they are dropped again. A client
+// waits chunkRetry for a requested chunk before asking again.
+const (
+	chunkMargin = 1
+	chunkKeep   = 3
+	chunkRetry  = 2 * time.Second
+)
+
+var (
+	// Chunks each player was sent of the map it is on, guarded by
+	// worldMutex
+	sentChunks = make(sim.SentChunks)
+)
+
+// forgetChunks clears what a player was sent, e.g. when it gets another map.
+func forgetChunks(playerID string) {
+	worldMutex.Lock()
+	delete(sentChunks, playerID)
+	worldMutex.Unlock()
+}
+
+// checkChunks clears what a player was sent if the map it has is not the
+// current version of the map it is on.
+func checkChunks(playerID string, name string, haveMap string, haveVersion string) {
+	worldMutex.Lock()
+	defer worldMutex.Unlock()
+	if l := world.Level(name); l != nil {
+		sentChunks.Check(playerID, l, haveMap, haveVersion)
+	} else {
+		delete(sentChunks, playerID)
+	}
+}
+
+// handleGetChunksWS sends the requested chunks of the player's map that it
+// wasn't sent yet. Chunks in "forget" were dropped by the client.
+func handleGetChunksWS(data map[string]string, conn MessageWriter) {
+	playerID := data["player_id"]
+	name := mapOf(playerID)
+
+	var messages [][]byte
+	worldMutex.Lock()
+	if l := world.Level(name); l != nil {
+		for _, chunk := range sentChunks.Request(playerID, l, data["chunks"], data["forget"]) {
+			msg, err := json.Marshal(chunk)
+			if err != nil {
+				log.Println("Error marshalling chunk:", err)
+				continue
+			}
+			messages = append(messages, msg)
+		}
+	}
+	worldMutex.Unlock()
+
+	for _, msg := range messages {
+		conn.WriteMessage(websocket.TextMessage, msg)
+	}
+}
+
+// mapUpdate is a map_data or chunk_data message.
+type mapUpdate struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"` // map_data
+	Map     string   `json:"map"`  // chunk_data
+	Version int      `json:"version"`
+	W       int      `json:"w"`
+	H       int      `json:"h"`
+	X       int      `json:"x"`
+	Y       int      `json:"y"`
+	Tiles   []int    `json:"tiles"`
+	Codes   []string `json:"codes"`
+	Clock   int64    `json:"clock"` // map_data, see worldClock
+}
+
+var (
+	// Map messages from the server, applied by the game loop so the map
+	// doesn't change while it is drawn. Guarded by mapUpdatesMutex.
+	mapUpdatesMutex sync.Mutex
+	mapUpdates      []mapUpdate
+
+	// The map the loaded chunks are of, only used by the game loop
+	chunkMap        string
+	mapVersion      int
+	loadedChunks    = make(map[sim.ChunkPos]bool)
+	requestedChunks = make(map[sim.ChunkPos]time.Time)
+)
+
+func queueMapUpdate(message []byte) {
+	var update mapUpdate
+	if err := json.Unmarshal(message, &update); err != nil {
+		log.Println("Error parsing map update:", err)
+		return
+	}
+	if update.Type == "map_data" && update.Name != "" {
+		clientMap = update.Name
+	}
+	if update.Clock != 0 {
+		syncClock(update.Clock)
+	}
+	mapUpdatesMutex.Lock()
+	mapUpdates = append(mapUpdates, update)
+	mapUpdatesMutex.Unlock()
+}
+
+func handleMapDataResponse(message []byte) {
+	queueMapUpdate(message)
+}
+
+func handleChunkDataResponse(message []byte) {
+	queueMapUpdate(message)
+}
+
+// applyMapUpdates applies the map messages that came in since the last
+// frame. A map_data for another map or version clears all chunks.
+func applyMapUpdates() {
+	mapUpdatesMutex.Lock()
+	updates := mapUpdates
+	mapUpdates = nil
+	mapUpdatesMutex.Unlock()
+
+	for _, update := range updates {
+		switch update.Type {
+		case "map_data":
+			if update.Name == chunkMap && update.Version == mapVersion && update.W == mapW && update.H == mapH {
+				continue
+			}
+			chunkMap, mapVersion = update.Name, update.Version
+			mapW, mapH = max(update.W, 0), max(update.H, 0)
+			tileMap = make([]int, mapW*mapH)
+			srcMap = make([]string, mapW*mapH)
+			loadedChunks = make(map[sim.ChunkPos]bool)
+			requestedChunks = make(map[sim.ChunkPos]time.Time)
+			dropChunkTextures()
+		case "chunk_data":
+			pos := sim.ChunkPos{update.X, update.Y}
+			col, row, w, h, ok := currentMap().ChunkArea(pos)
+			if update.Map != chunkMap || update.Version != mapVersion || !ok || update.W != w || update.H != h {
+				continue
+			}
+			for i, tile := range update.Tiles {
+				x, y := col+i%w, row+i/w
+				if y >= row+h {
+					break
+				}
+				tileMap[y*mapW+x] = tile
+				if i < len(update.Codes) {
+					srcMap[y*mapW+x] = update.Codes[i]
+				}
+			}
+			loadedChunks[pos] = true
+			delete(requestedChunks, pos)
+			dropChunkTexture(pos)
+		}
+	}
+}
+
+// updateChunks requests the chunks around the camera that this client
+// doesn't have yet and drops the ones far away.
+func updateChunks() {
+	applyMapUpdates()
+	if chunkMap == "" || websocket_client == nil {
+		return
+	}
+	m := currentMap()
+	first, last := visibleChunks()
+	cols, rows := m.ChunkCount()
+
+	now := time.Now()
+	var wanted, forget []sim.ChunkPos
+	for y := max(first.Y-chunkMargin, 0); y <= min(last.Y+chunkMargin, rows-1); y++ {
+		for x := max(first.X-chunkMargin, 0); x <= min(last.X+chunkMargin, cols-1); x++ {
+			pos := sim.ChunkPos{x, y}
+			if loadedChunks[pos] || now.Sub(requestedChunks[pos]) < chunkRetry || len(wanted) >= sim.MaxChunksPerRequest {
+				continue
+			}
+			requestedChunks[pos] = now
+			wanted = append(wanted, pos)
+		}
+	}
+	for pos := range loadedChunks {
+		if pos.X < first.X-chunkKeep || pos.X > last.X+chunkKeep || pos.Y < first.Y-chunkKeep || pos.Y > last.Y+chunkKeep {
+			m.ClearChunk(pos)
+			delete(loadedChunks, pos)
+			dropChunkTexture(pos)
+			forget = append(forget, pos)
+		}
+	}
+	if len(wanted) > 0 || len(forget) > 0 {
+		sendGetChunksWS(wanted, forget)
+	}
+}
+
+func sendGetChunksWS(wanted []sim.ChunkPos, forget []sim.ChunkPos) {
+	msg, _ := json.Marshal(map[string]string{
+		"command":   "get_chunks",
+		"chunks":    sim.FormatChunks(wanted),
+		"forget":    sim.FormatChunks(forget),
+		"player_id": joinPlayerID,
+	})
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending get_chunks request:", err)
+	}
+}
@@ -0,0 +1,53 @@
+// Command mapgen writes a generated map in the game's map format.
+//
+//	go run ./cmd/mapgen -seed 42 -w 64 -h 48 -o resource/maps/island.map
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"main/autotile"
+	"main/mapgen"
+	"main/sim"
+)
+
+func main() {
+	seed := flag.Int64("seed", time.Now().UnixNano(), "seed of the map, the same seed gives the same map")
+	w := flag.Int("w", 64, "width in tiles")
+	h := flag.Int("h", 48, "height in tiles")
+	out := flag.String("o", "", "file to write the map to (default stdout)")
+	tilesets := flag.String("tilesets", "resource/tilesets/tilesets.json", "tilesets with the autotile rules of the terrain")
+	flag.Parse()
+
+	rules, err := autotile.Load(*tilesets)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// Keep the land free where players spawn
+	x, y := sim.PlayerFeet(sim.SpawnDest)
+	m, err := mapgen.Generate(mapgen.Options{
+		Seed:     *seed,
+		W:        *w,
+		H:        *h,
+		SpawnCol: int(x/sim.TileSize) + 1,
+		SpawnRow: int(y/sim.TileSize) + 1,
+		Autotile: rules,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		fmt.Print(m.String())
+		return
+	}
+	if err := os.WriteFile(*out, []byte(m.String()), 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote map with seed %d to %s\n", *seed, *out)
+}
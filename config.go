@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/gameconfig"
+)
+
+var config = gameconfig.Default()
+
+// parseConfig parses the command line into the global config.
+func parseConfig() error {
+	parsed, err := gameconfig.Parse(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	config = parsed
+
+	if config.Map != "" {
+		map_file = config.Map
+		if filepath.Base(map_file) == map_file && !strings.HasPrefix(map_file, randomMapPrefix) {
+			map_file = filepath.Join("resource/maps", map_file)
+		}
+	}
+	return nil
+}
+
+// startOptions returns what the configured mode needs to start.
+func startOptions(c gameconfig.Config) StartOptions {
+	options := StartOptions{
+		Mode:    c.Mode,
+		Address: c.Addr,
+		Port:    strconv.Itoa(c.Port),
+		Invite:  c.Invite,
+		Name:    c.Name,
+	}
+	if c.Mode == "gateway" || c.Mode == "gatewayjoin" {
+		options.Address = c.Gateway
+	}
+	return options
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// MessageWriter is a connection the server handlers answer on, either a
+// player's connection or the gateway.
+type MessageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
+// SafeConnection serializes writes to a connection, so messages to a player
+// can be sent from other players' handlers.
+type SafeConnection struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+}
+
+func NewSafeConnection(conn *websocket.Conn) *SafeConnection {
+	return &SafeConnection{conn: conn}
+}
+
+// A write that takes longer fails, so a stalled client can't hold up the
+// handlers that send to it
+const writeWait = 10 * time.Second
+
+func (sc *SafeConnection) WriteMessage(messageType int, data []byte) error {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return sc.conn.WriteMessage(messageType, data)
+}
+
+func (sc *SafeConnection) Close() error {
+	return sc.conn.Close()
+}
+
+var (
+	// Connections of spawned players on the host server by player ID
+	wsClientsMutex sync.RWMutex
+	wsClients      = make(map[string]*SafeConnection)
+)
+
+func registerClient(playerID string, conn *SafeConnection) {
+	wsClientsMutex.Lock()
+	wsClients[playerID] = conn
+	wsClientsMutex.Unlock()
+}
+
+func unregisterClient(playerID string, conn *SafeConnection) {
+	wsClientsMutex.Lock()
+	if wsClients[playerID] == conn {
+		delete(wsClients, playerID)
+	}
+	wsClientsMutex.Unlock()
+}
+
+// gatewayConn returns the connection to the gateway, or nil when not
+// hosting through one.
+func gatewayConn() *SafeConnection {
+	return websocket_gateway.Load()
+}
+
+// broadcastGateway hands a message marked broadcast to the gateway, which
+// sends it to every player of the lobby.
+func broadcastGateway(msg []byte) {
+	if conn := gatewayConn(); conn != nil {
+		conn.WriteMessage(websocket.TextMessage, msg)
+	}
+}
+
+// broadcastWS sends a message to every player connected to the host server.
+func broadcastWS(msg []byte) {
+	wsClientsMutex.RLock()
+	defer wsClientsMutex.RUnlock()
+	for playerID, conn := range wsClients {
+		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			log.Printf("Error sending to player %s: %v", playerID, err)
+		}
+	}
+}
+
+// closeWithReason sends a close frame with the given code before closing.
+func closeWithReason(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
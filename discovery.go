@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/discovery"
+)
+
+// startAnnouncer announces the hosted game on the LAN until the game ends.
+func startAnnouncer(port string) {
+	name := config.Name
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	err := discovery.Announce(discovery.Port, nil, func() discovery.Announcement {
+		playersMutex.RLock()
+		players := len(joinedPlayers)
+		playersMutex.RUnlock()
+		return discovery.Announcement{
+			Name:    name,
+			Port:    port,
+			TLS:     serverTLSEnabled(),
+			Players: players,
+			Map:     filepath.Base(map_file),
+		}
+	})
+	if err != nil {
+		log.Println("LAN announce disabled:", err)
+	}
+}
+
+// chooseDiscoveredGame lists the games on the LAN in the terminal and asks
+// which one to join.
+func chooseDiscoveredGame() (string, error) {
+	fmt.Println("Searching for games on the LAN...")
+	games, err := discovery.Discover(discovery.Port, discovery.Duration)
+	if err != nil {
+		return "", err
+	}
+	if len(games) == 0 {
+		return "", fmt.Errorf("no games found")
+	}
+
+	for i, game := range games {
+		fmt.Printf("%d) %s on %s - %d players, map %s\n", i+1, game.Name, game.Address, game.Players, game.Map)
+	}
+	fmt.Print("Join game: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(games) {
+		return "", fmt.Errorf("invalid choice %q", strings.TrimSpace(line))
+	}
+	return games[choice-1].URL(), nil
+}
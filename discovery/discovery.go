@@ -0,0 +1,146 @@
+// Package discovery finds games hosted on the LAN. Hosts broadcast an
+// Announcement every second, players listen for them for a few seconds.
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	Port     = 47777
+	Interval = time.Second
+	Duration = 3 * time.Second
+
+	// Announcements of other programs on the port are ignored
+	gameID = "first-go-game"
+)
+
+// Announcement is broadcast by hosts so others on the LAN can find them.
+type Announcement struct {
+	Game    string `json:"game"`
+	Name    string `json:"name"`
+	Port    string `json:"port"`
+	TLS     bool   `json:"tls"`
+	Players int    `json:"players"`
+	Map     string `json:"map"`
+}
+
+// Game is a host found on the LAN.
+type Game struct {
+	Announcement
+	Address  string
+	LastSeen time.Time
+}
+
+// URL returns the address to join the game with.
+func (g Game) URL() string {
+	if g.TLS {
+		return "wss://" + g.Address
+	}
+	return g.Address
+}
+
+// Announce sends the announcement returned by announcement to port every
+// Interval until stop is closed. It is broadcast and also sent to
+// loopback, so games on the same machine are found without a network.
+func Announce(port int, stop <-chan struct{}, announcement func() Announcement) error {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	targets := []*net.UDPAddr{
+		{IP: net.IPv4bcast, Port: port},
+		{IP: net.IPv4(127, 0, 0, 1), Port: port},
+	}
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		a := announcement()
+		a.Game = gameID
+		msg, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		for _, target := range targets {
+			conn.WriteTo(msg, target)
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Discover listens on port for announcements for the given duration and
+// returns the games heard, ordered by address.
+func Discover(port int, duration time.Duration) ([]Game, error) {
+	conn, err := net.ListenPacket("udp4", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	found := make(map[string]Game)
+	conn.SetReadDeadline(time.Now().Add(duration))
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+
+		var announcement Announcement
+		if err := json.Unmarshal(buf[:n], &announcement); err != nil || announcement.Game != gameID {
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			continue
+		}
+		address := net.JoinHostPort(host, announcement.Port)
+		found[address] = Game{
+			Announcement: announcement,
+			Address:      address,
+			LastSeen:     time.Now(),
+		}
+	}
+
+	games := make([]Game, 0, len(found))
+	for _, game := range found {
+		// A host on this machine is heard twice, prefer its LAN address
+		host, _, _ := net.SplitHostPort(game.Address)
+		if isLoopback(host) && hasLANAddress(found, game) {
+			continue
+		}
+		games = append(games, game)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].Address < games[j].Address
+	})
+	return games, nil
+}
+
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func hasLANAddress(found map[string]Game, local Game) bool {
+	for _, game := range found {
+		host, _, _ := net.SplitHostPort(game.Address)
+		if !isLoopback(host) && game.Name == local.Name && game.Port == local.Port {
+			return true
+		}
+	}
+	return false
+}
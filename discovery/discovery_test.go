@@ -0,0 +1,137 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort returns a UDP port on loopback that nothing listens on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestLoopbackDiscovery(t *testing.T) {
+	port := freePort(t)
+	stop := make(chan struct{})
+	announced := make(chan error, 1)
+
+	type result struct {
+		games []Game
+		err   error
+	}
+	discovered := make(chan result, 1)
+	go func() {
+		games, err := Discover(port, 1500*time.Millisecond)
+		discovered <- result{games, err}
+	}()
+	// Let Discover listen before the first announcement goes out
+	time.Sleep(100 * time.Millisecond)
+
+	// Other programs on the port are ignored
+	other, err := net.Dial("udp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Write([]byte("not json"))
+	foreign, _ := json.Marshal(Announcement{Game: "another-game", Name: "other", Port: "9999"})
+	other.Write(foreign)
+
+	go func() {
+		announced <- Announce(port, stop, func() Announcement {
+			return Announcement{Name: "test host", Port: "8080", Players: 2, Map: "test.map"}
+		})
+	}()
+
+	found := <-discovered
+	close(stop)
+	if err := <-announced; err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if found.err != nil {
+		t.Fatalf("Discover: %v", found.err)
+	}
+	if len(found.games) != 1 {
+		t.Fatalf("found %+v, want the one announced game", found.games)
+	}
+	// With a network the broadcast arrives too and its LAN address wins
+	game := found.games[0]
+	host, gamePort, _ := net.SplitHostPort(game.Address)
+	if !localAddress(t, host) || gamePort != "8080" {
+		t.Fatalf("found the game at %s, want an address of this machine with port 8080", game.Address)
+	}
+	if game.Name != "test host" || game.Players != 2 || game.Map != "test.map" || game.TLS {
+		t.Fatalf("found %+v", game.Announcement)
+	}
+}
+
+// localAddress reports whether host is loopback or an address of this
+// machine.
+func localAddress(t *testing.T, host string) bool {
+	t.Helper()
+	if isLoopback(host) {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == host {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGameURL(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  bool
+		want string
+	}{
+		{"ws", false, "192.168.1.5:8080"},
+		{"wss", true, "wss://192.168.1.5:8080"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			game := Game{Announcement: Announcement{TLS: test.tls}, Address: "192.168.1.5:8080"}
+			if got := game.URL(); got != test.want {
+				t.Fatalf("URL() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPreferLANAddress(t *testing.T) {
+	found := map[string]Game{
+		"127.0.0.1:8080":   {Announcement: Announcement{Name: "host", Port: "8080"}, Address: "127.0.0.1:8080"},
+		"192.168.1.5:8080": {Announcement: Announcement{Name: "host", Port: "8080"}, Address: "192.168.1.5:8080"},
+		"127.0.0.1:9090":   {Announcement: Announcement{Name: "local", Port: "9090"}, Address: "127.0.0.1:9090"},
+	}
+	tests := []struct {
+		address string
+		skip    bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"192.168.1.5:8080", false},
+		{"127.0.0.1:9090", false},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			host, _, _ := net.SplitHostPort(test.address)
+			if skip := isLoopback(host) && hasLANAddress(found, found[test.address]); skip != test.skip {
+				t.Fatalf("skipped %v, want %v", skip, test.skip)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+// Frames of doors.png, the map's tile index of a door is not used
+const (
+	doorOpenFrame   = 1
+	doorClosedFrame = 2
+)
+
+var (
+	// What this client got from the server, guarded by doorsMutex
+	doorsMutex sync.RWMutex
+	openDoors  = make(map[int]bool)
+)
+
+// toggleDoor opens or closes a door next to a player.
+func toggleDoor(playerID string, tile int) error {
+	playersMutex.RLock()
+	defer playersMutex.RUnlock()
+	player, exists := joinedPlayers[playerID]
+	if !exists {
+		return fmt.Errorf("not spawned")
+	}
+	name := playerMap(playerID)
+	var others []sim.Rect
+	for id, other := range joinedPlayers {
+		if playerMap(id) == name {
+			others = append(others, sim.Rect(other["playerDest"]))
+		}
+	}
+
+	worldMutex.Lock()
+	defer worldMutex.Unlock()
+	l := world.Level(name)
+	if l == nil {
+		return fmt.Errorf("no door there")
+	}
+	return l.ToggleDoor(tile, sim.Rect(player["playerDest"]), others)
+}
+
+// doorsMessage returns the open doors of a map for a player, or for
+// everyone if broadcast is set.
+func doorsMessage(playerID string, name string, broadcast bool) []byte {
+	worldMutex.Lock()
+	open := []int{}
+	if l := world.Level(name); l != nil {
+		name = l.Name
+		open = l.OpenDoors()
+	}
+	worldMutex.Unlock()
+	response := map[string]interface{}{
+		"type":      "doors",
+		"map":       name,
+		"open":      open,
+		"player_id": playerID,
+	}
+	if broadcast {
+		response["broadcast"] = true
+	}
+	msg, err := json.Marshal(response)
+	if err != nil {
+		log.Println("Error marshalling doors:", err)
+	}
+	return msg
+}
+
+func handleToggleDoorWS(data map[string]string, conn MessageWriter, broadcast func([]byte)) {
+	playerID := data["player_id"]
+	tile, err := strconv.Atoi(data["tile"])
+	if err == nil {
+		err = toggleDoor(playerID, tile)
+	}
+	if err != nil {
+		response, _ := json.Marshal(map[string]string{
+			"type":      "door_error",
+			"error":     err.Error(),
+			"player_id": playerID,
+		})
+		conn.WriteMessage(websocket.TextMessage, response)
+		return
+	}
+	broadcast(doorsMessage(playerID, mapOf(playerID), true))
+}
+
+func handleDoorsResponse(message []byte) {
+	var response struct {
+		Map  string `json:"map"`
+		Open []int  `json:"open"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		log.Println("Error parsing doors:", err)
+		return
+	}
+	if !onClientMap(response.Map) {
+		return
+	}
+	doorsMutex.Lock()
+	openDoors = make(map[int]bool)
+	for _, tile := range response.Open {
+		openDoors[tile] = true
+	}
+	doorsMutex.Unlock()
+}
+
+func sendToggleDoorWS(tile int) {
+	if websocket_client == nil {
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"command":   "toggle_door",
+		"tile":      strconv.Itoa(tile),
+		"player_id": joinPlayerID,
+	})
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending toggle_door request:", err)
+	}
+}
+
+// interact farms the tilled tile the player stands on with the selected
+// hotbar slot, or toggles a door next to the player.
+func interact() {
+	m := currentMap()
+	x, y := playerFeet(playerDest)
+	if tile := m.TileAt(x, y); m.Code(tile) == "t" {
+		sendFarmWS(tile)
+		return
+	}
+	if door := m.DoorNear(x, y, 1); door != -1 {
+		sendToggleDoorWS(door)
+	}
+}
+
+// doorFrame returns the frame of doors.png to draw for the door on a tile.
+func doorFrame(tile int) int {
+	doorsMutex.RLock()
+	defer doorsMutex.RUnlock()
+	if openDoors[tile] {
+		return doorOpenFrame
+	}
+	return doorClosedFrame
+}
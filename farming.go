@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+const (
+	cropUpdateInterval = time.Second
+
+	// Milliseconds of the world clock per radian of the crop sway
+	cropSwayPeriod = 400
+)
+
+// Colors of the ripe crops by kind
+var cropColors = map[string]rl.Color{
+	"wheat":  rl.Gold,
+	"carrot": rl.Orange,
+}
+
+var (
+	// worldMutex guards the maps of the world with their crops and items
+	// on the host server, and the inventories
+	worldMutex sync.Mutex
+
+	// What this client got from the server, guarded by cropsMutex
+	cropsMutex     sync.RWMutex
+	crops          = make(map[int]sim.Crop)
+	lastCropUpdate time.Time
+)
+
+// farm plants, waters or harvests the crop on a tile for a player.
+func farm(playerID string, tile int, slot int) error {
+	playersMutex.RLock()
+	player, exists := joinedPlayers[playerID]
+	var dest rl.Rectangle
+	if exists {
+		dest = player["playerDest"]
+	}
+	name := playerMap(playerID)
+	playersMutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("not spawned")
+	}
+
+	worldMutex.Lock()
+	defer worldMutex.Unlock()
+	l := world.Level(name)
+	if l == nil {
+		return fmt.Errorf("nothing to farm there")
+	}
+	return world.Farm(l, playerID, sim.Rect(dest), tile, slot)
+}
+
+// cropsMessage returns the crops of a map for a player, or for everyone
+// if broadcast is set.
+func cropsMessage(playerID string, name string, broadcast bool) []byte {
+	worldMutex.Lock()
+	response := map[string]interface{}{
+		"type":      "crops",
+		"map":       name,
+		"crops":     map[int]*sim.Crop{},
+		"player_id": playerID,
+	}
+	if l := world.Level(name); l != nil {
+		response["map"] = l.Name
+		response["crops"] = l.GrownCrops(time.Now())
+	}
+	if broadcast {
+		response["broadcast"] = true
+	}
+	msg, err := json.Marshal(response)
+	worldMutex.Unlock()
+	if err != nil {
+		log.Println("Error marshalling crops:", err)
+	}
+	return msg
+}
+
+// handleFarmWS farms a tile for a player. The player gets its new
+// inventory, everyone gets the changed crops.
+func handleFarmWS(data map[string]string, conn MessageWriter, broadcast func([]byte)) {
+	playerID := data["player_id"]
+	tile, err := strconv.Atoi(data["tile"])
+	if err == nil {
+		var slot int
+		slot, err = strconv.Atoi(data["slot"])
+		if err == nil {
+			err = farm(playerID, tile, slot)
+		}
+	}
+	if err != nil {
+		response, _ := json.Marshal(map[string]string{
+			"type":      "farm_error",
+			"error":     err.Error(),
+			"player_id": playerID,
+		})
+		conn.WriteMessage(websocket.TextMessage, response)
+		return
+	}
+	name := mapOf(playerID)
+	conn.WriteMessage(websocket.TextMessage, inventoryMessage(playerID))
+	broadcast(cropsMessage(playerID, name, true))
+	broadcast(groundItemsMessage(playerID, name, true))
+}
+
+func handleGetCropsWS(data map[string]string, conn MessageWriter) {
+	playerID := data["player_id"]
+	conn.WriteMessage(websocket.TextMessage, cropsMessage(playerID, mapOf(playerID), false))
+}
+
+func handleCropsResponse(message []byte) {
+	var response struct {
+		Map   string           `json:"map"`
+		Crops map[int]sim.Crop `json:"crops"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		log.Println("Error parsing crops:", err)
+		return
+	}
+	if !onClientMap(response.Map) {
+		return
+	}
+	cropsMutex.Lock()
+	crops = response.Crops
+	cropsMutex.Unlock()
+}
+
+func requestCropsWS() {
+	if websocket_client == nil {
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"command":   "get_crops",
+		"player_id": joinPlayerID,
+	})
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending get_crops request:", err)
+	}
+}
+
+func sendFarmWS(tile int) {
+	if websocket_client == nil {
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"command":   "farm",
+		"tile":      strconv.Itoa(tile),
+		"slot":      strconv.Itoa(selectedSlot),
+		"player_id": joinPlayerID,
+	})
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending farm request:", err)
+	}
+}
+
+// drawCrops draws the crops on their tiles with simple shapes, wet soil is
+// darker.
+func drawCrops() {
+	m := currentMap()
+	bounds := cameraBounds()
+	cropsMutex.RLock()
+	defer cropsMutex.RUnlock()
+	for tile, crop := range crops {
+		if tile < 0 || tile >= m.W*m.H {
+			continue
+		}
+		rect := tileRect(m, tile)
+		if !rl.CheckCollisionRecs(rect, bounds) {
+			continue
+		}
+		if crop.Watered {
+			rl.DrawRectangleRec(rect, rl.Fade(rl.DarkBrown, 0.35))
+		}
+		bottom := rl.NewVector2(rect.X+rect.Width/2, rect.Y+rect.Height-3)
+		green := rl.NewColor(76, 153, 0, 255)
+		switch crop.Stage {
+		case 0:
+			for _, dx := range []float32{-4, 0, 4} {
+				rl.DrawCircleV(rl.NewVector2(bottom.X+dx, bottom.Y-1), 1, rl.Brown)
+			}
+		case 1:
+			rl.DrawLineEx(bottom, rl.NewVector2(bottom.X, bottom.Y-4), 1, green)
+			rl.DrawCircleV(rl.NewVector2(bottom.X+1, bottom.Y-4), 1.5, green)
+		default:
+			height := float32(8)
+			if crop.Stage == sim.CropRipe {
+				height = 10
+			}
+			// Grown crops sway in the wind, the same on every client
+			sway := float32(math.Sin(float64(worldClock())/cropSwayPeriod+float64(tile)*0.7)) * 0.8
+			for _, dx := range []float32{-3, 0, 3} {
+				top := rl.NewVector2(bottom.X+dx+sway, bottom.Y-height+abs(dx)/2)
+				rl.DrawLineEx(bottom, top, 1, green)
+				if crop.Stage == sim.CropRipe {
+					rl.DrawCircleV(top, 2, cropColors[crop.Kind])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package gameconfig reads how the game is started, from the command line
+// and a JSON config file, and the player's settings file. It only parses
+// and checks values, the game applies them.
+package gameconfig
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/ratelimit"
+)
+
+// Read when it exists and no -config is given
+const DefaultConfigFile = "game.json"
+
+// Config holds the game settings. Values are read from the JSON file given
+// with -config (or game.json), command line flags override them.
+type Config struct {
+	Mode    string `json:"mode"`
+	Addr    string `json:"addr"`    // server to join
+	Port    int    `json:"port"`    // port to host on
+	Gateway string `json:"gateway"` // gateway to host or join a lobby on
+	Invite  string `json:"invite"`  // lobby to join on the gateway
+	Map     string `json:"map"`     // map or world to host, a file in resource/maps or a path
+	Name    string `json:"name"`
+
+	TLSCert string `json:"tls_cert"` // the host server uses wss:// when set
+	TLSKey  string `json:"tls_key"`
+	CA      string `json:"ca"` // CA certificate to trust for wss:// connections
+
+	// Origins browsers may connect from, "*" allows all
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	// Announce host mode games on the LAN
+	Announce bool `json:"announce"`
+
+	// Maximum size of a single message from a client in bytes
+	ClientReadLimit int64            `json:"client_read_limit"`
+	ClientLimits    ratelimit.Config `json:"client_limits"`
+}
+
+func Default() Config {
+	return Config{
+		Addr:     "localhost:8080",
+		Port:     8080,
+		Announce: true,
+
+		ClientReadLimit: 4 * 1024,
+		ClientLimits: ratelimit.Config{
+			Connection: ratelimit.Limit{Rate: 100, Burst: 200},
+			Commands: map[string]ratelimit.Limit{
+				"respawn":     {Rate: 1, Burst: 3},
+				"get_map":     {Rate: 2, Burst: 5},
+				"get_chunks":  {Rate: 10, Burst: 20},
+				"get_players": {Rate: 30, Burst: 60},
+				"player_data": {Rate: 70, Burst: 120},
+				"chat":        {Rate: 2, Burst: 10},
+				"farm":        {Rate: 5, Burst: 10},
+				"get_crops":   {Rate: 2, Burst: 5},
+				"drop":        {Rate: 5, Burst: 10},
+				"move_item":   {Rate: 5, Burst: 10},
+				"toggle_door": {Rate: 5, Burst: 10},
+			},
+			MaxStrikes: 200,
+			MaxInvalid: 20,
+		},
+	}
+}
+
+func loadConfig(path string, cfg *Config) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(file, cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+var usageModes = []struct {
+	Mode string
+	Args string
+	Help string
+}{
+	{"host", "-port 8080", "host a game on this computer"},
+	{"join", "-addr host:port", "join a game on the LAN or the internet"},
+	{"discover", "", "search the LAN for games and join one"},
+	{"gateway", "-gateway host:port", "open a lobby on a gateway and play in it"},
+	{"gatewayjoin", "-gateway host:port -invite code", "join a lobby on a gateway"},
+	{"bench", "", "measure how fast a large generated map is drawn"},
+}
+
+func usage(flags *flag.FlagSet) {
+	out := flags.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [mode]\n\n", flags.Name())
+	fmt.Fprintln(out, "Modes, without a mode the main menu opens:")
+	for _, mode := range usageModes {
+		fmt.Fprintf(out, "  %-12s %-32s %s\n", mode.Mode, mode.Args, mode.Help)
+	}
+	fmt.Fprintln(out, "\nThe old form <mode> [port|address] [invite code] works too.")
+	fmt.Fprintln(out, "\nFlags:")
+	flags.PrintDefaults()
+}
+
+// Parse reads the command line arguments after the program name. The
+// config file is read first, only flags that are given override it. It
+// returns flag.ErrHelp for -help.
+func Parse(args []string) (Config, error) {
+	config := Default()
+	flags := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a JSON config file (default "+DefaultConfigFile+" if it exists)")
+	mode := flags.String("mode", "", "host, join, discover, gateway, gatewayjoin or bench")
+	addr := flags.String("addr", config.Addr, "address of the server to join")
+	port := flags.Int("port", config.Port, "port to host on")
+	gateway := flags.String("gateway", config.Gateway, "address of the gateway")
+	invite := flags.String("invite", "", "invite code of the gateway lobby to join")
+	mapFile := flags.String("map", "", "map or world file to host, in resource/maps or a path, or random:<seed> for a generated map")
+	name := flags.String("name", "", "player name")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file, the host server uses wss:// when set")
+	tlsKey := flags.String("tls-key", "", "TLS key file")
+	caFile := flags.String("ca", "", "CA certificate to trust for wss:// connections")
+	allowedOrigins := flags.String("allowed-origins", "", "comma separated list of origins browsers may connect from, * allows all")
+	announce := flags.Bool("announce", config.Announce, "announce host mode games on the LAN")
+	flags.Usage = func() { usage(flags) }
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *configFile != "" {
+		if err := loadConfig(*configFile, &config); err != nil {
+			return config, err
+		}
+	} else if err := loadConfig(DefaultConfigFile, &config); err != nil && !os.IsNotExist(err) {
+		return config, err
+	}
+
+	// Only flags given on the command line override the config file
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "mode":
+			config.Mode = *mode
+		case "addr":
+			config.Addr = *addr
+		case "port":
+			config.Port = *port
+		case "gateway":
+			config.Gateway = *gateway
+		case "invite":
+			config.Invite = *invite
+		case "map":
+			config.Map = *mapFile
+		case "name":
+			config.Name = *name
+		case "tls-cert":
+			config.TLSCert = *tlsCert
+		case "tls-key":
+			config.TLSKey = *tlsKey
+		case "ca":
+			config.CA = *caFile
+		case "allowed-origins":
+			config.AllowedOrigins = strings.Split(*allowedOrigins, ",")
+		case "announce":
+			config.Announce = *announce
+		}
+	})
+
+	err := config.applyLegacyArgs(flags.Args())
+	return config, err
+}
+
+// applyLegacyArgs reads the positional arguments the launcher uses:
+// <mode> [port|address] [invite code].
+func (config *Config) applyLegacyArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	config.Mode = args[0]
+	if len(args) > 3 {
+		return fmt.Errorf("too many arguments, see -help")
+	}
+	if len(args) < 2 {
+		return nil
+	}
+	switch config.Mode {
+	case "host":
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid port %q", args[1])
+		}
+		config.Port = port
+	case "join":
+		config.Addr = args[1]
+	case "gateway", "gatewayjoin":
+		config.Gateway = args[1]
+	}
+	if len(args) == 3 {
+		config.Invite = args[2]
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package gameconfig
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // config file given with -config, none if empty
+		args []string
+		want func(c *Config)
+	}{
+		{"defaults", "", nil, func(c *Config) {}},
+		{"file", `{"port": 9000, "name": "file"}`, nil, func(c *Config) {
+			c.Port = 9000
+			c.Name = "file"
+		}},
+		{"flag over file", `{"port": 9000, "name": "file"}`, []string{"-port", "9100"}, func(c *Config) {
+			c.Port = 9100
+			c.Name = "file"
+		}},
+		{"flag with the default value over file", `{"port": 9000}`, []string{"-port", "8080"}, func(c *Config) {}},
+		{"file without flag", `{"announce": false}`, nil, func(c *Config) {
+			c.Announce = false
+		}},
+		{"bool flag over file", `{"announce": false}`, []string{"-announce=true"}, func(c *Config) {}},
+		{"file keeps limits not in it", `{"client_read_limit": 100}`, nil, func(c *Config) {
+			c.ClientReadLimit = 100
+		}},
+		{"allowed origins", "", []string{"-allowed-origins", "https://a.example,https://b.example"}, func(c *Config) {
+			c.AllowedOrigins = []string{"https://a.example", "https://b.example"}
+		}},
+		{"mode flag", "", []string{"-mode", "gatewayjoin", "-gateway", "gw:8081", "-invite", "abc"}, func(c *Config) {
+			c.Mode = "gatewayjoin"
+			c.Gateway = "gw:8081"
+			c.Invite = "abc"
+		}},
+		{"legacy mode", "", []string{"discover"}, func(c *Config) {
+			c.Mode = "discover"
+		}},
+		{"legacy host", "", []string{"host", "9000"}, func(c *Config) {
+			c.Mode = "host"
+			c.Port = 9000
+		}},
+		{"legacy join", "", []string{"join", "example.com:8080"}, func(c *Config) {
+			c.Mode = "join"
+			c.Addr = "example.com:8080"
+		}},
+		{"legacy gatewayjoin", "", []string{"gatewayjoin", "gw:8081", "abc"}, func(c *Config) {
+			c.Mode = "gatewayjoin"
+			c.Gateway = "gw:8081"
+			c.Invite = "abc"
+		}},
+		{"legacy over file", `{"mode": "join", "addr": "file:1"}`, []string{"join", "args:2"}, func(c *Config) {
+			c.Mode = "join"
+			c.Addr = "args:2"
+		}},
+		{"flags before legacy args", "", []string{"-name", "flag", "host", "9000"}, func(c *Config) {
+			c.Mode = "host"
+			c.Port = 9000
+			c.Name = "flag"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := test.args
+			if test.file != "" {
+				path := filepath.Join(t.TempDir(), "game.json")
+				if err := os.WriteFile(path, []byte(test.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			got, err := Parse(args)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", args, err)
+			}
+			want := Default()
+			test.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("Parse(%q) = %+v, want %+v", args, got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	broken := filepath.Join(t.TempDir(), "broken.json")
+	os.WriteFile(broken, []byte(`{"port": `), 0o600)
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"legacy port not a number", []string{"host", "eighty"}},
+		{"too many arguments", []string{"gatewayjoin", "gw:8081", "abc", "extra"}},
+		{"unknown flag", []string{"-speed", "9000"}},
+		{"broken config file", []string{"-config", broken}},
+		{"missing config file", []string{"-config", filepath.Join(t.TempDir(), "missing.json")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.args); err == nil {
+				t.Fatalf("Parse(%q) did not fail", test.args)
+			}
+		})
+	}
+}
+
+func TestParseHelp(t *testing.T) {
+	if _, err := Parse([]string{"-help"}); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("Parse(-help) = %v, want flag.ErrHelp", err)
+	}
+}
+
+func known(names ...string) func(string) bool {
+	return func(name string) bool { return slices.Contains(names, name) }
+}
+
+func TestLoadSettings(t *testing.T) {
+	knownKey := known("W", "Up", "A", "Left", "S", "Down", "D", "Right", "E", "Q", "Enter", "R", "F", "G", "Tab", "K")
+	knownButton := known("DPadUp", "DPadLeft", "DPadDown", "DPadRight", "A", "B", "X", "Y", "LB", "RB")
+	tests := []struct {
+		name string
+		file string
+		want func(s *Settings)
+	}{
+		{"empty", `{}`, func(s *Settings) {}},
+		{"resolution too small", `{"width": 10, "height": 10}`, func(s *Settings) {
+			s.Width, s.Height = MinWidth, MinHeight
+		}},
+		{"resolution too big", `{"width": 100000, "height": 100000}`, func(s *Settings) {
+			s.Width, s.Height = MaxWidth, MaxHeight
+		}},
+		{"zoom zero", `{"zoom": 0}`, func(s *Settings) { s.Zoom = MinZoom }},
+		{"zoom too big", `{"zoom": 10}`, func(s *Settings) { s.Zoom = MaxZoom }},
+		{"volumes", `{"master_volume": -1, "music_volume": 2}`, func(s *Settings) {
+			s.MasterVolume, s.MusicVolume = 0, 1
+		}},
+		{"deadzone", `{"deadzone": 1}`, func(s *Settings) { s.Deadzone = MaxDeadzone }},
+		{"known key kept", `{"keys": {"interact": ["K"]}}`, func(s *Settings) {
+			s.Keys[ActionInteract] = []string{"K"}
+		}},
+		{"unknown key dropped", `{"keys": {"interact": ["Nope", "K"]}}`, func(s *Settings) {
+			s.Keys[ActionInteract] = []string{"K"}
+		}},
+		{"only unknown keys get the default", `{"keys": {"interact": ["Nope"]}}`, func(s *Settings) {}},
+		{"unknown action dropped", `{"keys": {"fly": ["K"]}}`, func(s *Settings) {}},
+		{"button without a default kept", `{"gamepad": {"chat": ["X"]}}`, func(s *Settings) {
+			s.Gamepad[ActionChat] = []string{"X"}
+		}},
+		{"unknown button dropped", `{"gamepad": {"chat": ["Start"]}}`, func(s *Settings) {}},
+		{"menu fields kept", `{"last_addr": "example.com:8080", "last_name": "me"}`, func(s *Settings) {
+			s.LastAddr, s.LastName = "example.com:8080", "me"
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "settings.json")
+			if err := os.WriteFile(path, []byte(test.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadSettings(path, knownKey, knownButton)
+			if err != nil {
+				t.Fatalf("LoadSettings(%s): %v", test.file, err)
+			}
+			want := DefaultSettings()
+			test.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("LoadSettings(%s) = %+v, want %+v", test.file, got, want)
+			}
+		})
+	}
+}
+
+func TestLoadSettingsFile(t *testing.T) {
+	all := func(string) bool { return true }
+	dir := t.TempDir()
+
+	got, err := LoadSettings(filepath.Join(dir, "missing.json"), all, all)
+	if err != nil || !reflect.DeepEqual(got, DefaultSettings()) {
+		t.Fatalf("LoadSettings(missing) = %+v, %v, want the defaults", got, err)
+	}
+
+	broken := filepath.Join(dir, "broken.json")
+	os.WriteFile(broken, []byte(`{"width": `), 0o600)
+	if _, err := LoadSettings(broken, all, all); err == nil {
+		t.Fatal("LoadSettings(broken) did not fail")
+	}
+
+	saved := DefaultSettings()
+	saved.Width, saved.Zoom, saved.LastName = 1920, 2, "me"
+	path := filepath.Join(dir, "nested", "settings.json")
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err = LoadSettings(path, all, all)
+	if err != nil || !reflect.DeepEqual(got, saved) {
+		t.Fatalf("LoadSettings after Save = %+v, %v, want %+v", got, err, saved)
+	}
+}
@@ -0,0 +1,181 @@
+package gameconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Action is something the player can do, bound to keys and gamepad buttons
+// in the settings.
+type Action string
+
+const (
+	ActionMoveUp      Action = "up"
+	ActionMoveLeft    Action = "left"
+	ActionMoveDown    Action = "down"
+	ActionMoveRight   Action = "right"
+	ActionInteract    Action = "interact"
+	ActionToggleMusic Action = "toggle_music"
+	ActionChat        Action = "chat"
+	ActionNextSlot    Action = "next_slot"
+	ActionPrevSlot    Action = "prev_slot"
+	ActionDrop        Action = "drop"
+	ActionInventory   Action = "inventory"
+)
+
+var actions = []Action{
+	ActionMoveUp, ActionMoveLeft, ActionMoveDown, ActionMoveRight,
+	ActionInteract, ActionToggleMusic, ActionChat,
+	ActionNextSlot, ActionPrevSlot, ActionDrop, ActionInventory,
+}
+
+// Limits of the settings, values outside are clamped when loading
+const (
+	MinWidth, MaxWidth   = 320, 7680
+	MinHeight, MaxHeight = 240, 4320
+	MinZoom, MaxZoom     = 0.5, 4
+	MaxDeadzone          = 0.9
+)
+
+// Settings are the player's preferences, kept in the user config directory
+// across runs.
+type Settings struct {
+	Width      int32   `json:"width"`
+	Height     int32   `json:"height"`
+	Fullscreen bool    `json:"fullscreen"`
+	Zoom       float32 `json:"zoom"`
+
+	MasterVolume float32 `json:"master_volume"`
+	MusicVolume  float32 `json:"music_volume"`
+	Music        bool    `json:"music"`
+
+	// Action -> key and gamepad button names, the game knows which names
+	// exist
+	Keys     map[Action][]string `json:"keys"`
+	Gamepad  map[Action][]string `json:"gamepad"`
+	Deadzone float32             `json:"deadzone"`
+
+	// Filled into the main menu
+	LastAddr    string `json:"last_addr"`
+	LastPort    string `json:"last_port"`
+	LastGateway string `json:"last_gateway"`
+	LastInvite  string `json:"last_invite"`
+	LastName    string `json:"last_name"`
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Width:        1000,
+		Height:       480,
+		Zoom:         1.5,
+		MasterVolume: 1,
+		MusicVolume:  1,
+		Keys: map[Action][]string{
+			ActionMoveUp:      {"W", "Up"},
+			ActionMoveLeft:    {"A", "Left"},
+			ActionMoveDown:    {"S", "Down"},
+			ActionMoveRight:   {"D", "Right"},
+			ActionInteract:    {"E"},
+			ActionToggleMusic: {"Q"},
+			ActionChat:        {"Enter"},
+			ActionNextSlot:    {"R"},
+			ActionPrevSlot:    {"F"},
+			ActionDrop:        {"G"},
+			ActionInventory:   {"Tab"},
+		},
+		Gamepad: map[Action][]string{
+			ActionMoveUp:      {"DPadUp"},
+			ActionMoveLeft:    {"DPadLeft"},
+			ActionMoveDown:    {"DPadDown"},
+			ActionMoveRight:   {"DPadRight"},
+			ActionInteract:    {"A"},
+			ActionToggleMusic: {"Y"},
+			ActionNextSlot:    {"RB"},
+			ActionPrevSlot:    {"LB"},
+			ActionDrop:        {"B"},
+			ActionInventory:   {"X"},
+		},
+		Deadzone: 0.25,
+	}
+}
+
+// SettingsPath returns where the settings file is kept.
+func SettingsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "first-go-game", "settings.json"), nil
+}
+
+// LoadSettings reads a settings file, settings missing from it keep their
+// defaults. Without a file the defaults are returned.
+func LoadSettings(path string, knownKey, knownButton func(string) bool) (Settings, error) {
+	settings := DefaultSettings()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return DefaultSettings(), fmt.Errorf("parsing %s: %w", path, err)
+	}
+	settings.Validate(knownKey, knownButton)
+	return settings, nil
+}
+
+// Validate clamps values to their limits and drops bindings of unknown
+// actions, keys and buttons. Actions left without any binding get their
+// default ones back.
+func (s *Settings) Validate(knownKey, knownButton func(string) bool) {
+	s.Width = clamp(s.Width, MinWidth, MaxWidth)
+	s.Height = clamp(s.Height, MinHeight, MaxHeight)
+	s.Zoom = clamp(s.Zoom, MinZoom, MaxZoom)
+	s.MasterVolume = clamp(s.MasterVolume, 0, 1)
+	s.MusicVolume = clamp(s.MusicVolume, 0, 1)
+	s.Deadzone = clamp(s.Deadzone, 0, MaxDeadzone)
+
+	defaults := DefaultSettings()
+	s.Keys = validBindings(s.Keys, defaults.Keys, knownKey)
+	s.Gamepad = validBindings(s.Gamepad, defaults.Gamepad, knownButton)
+}
+
+func validBindings(bindings, defaults map[Action][]string, known func(string) bool) map[Action][]string {
+	valid := make(map[Action][]string)
+	for _, action := range actions {
+		for _, name := range bindings[action] {
+			if known(name) {
+				valid[action] = append(valid[action], name)
+			}
+		}
+		if len(valid[action]) == 0 && len(defaults[action]) > 0 {
+			valid[action] = defaults[action]
+		}
+	}
+	return valid
+}
+
+// Save writes the settings file, creating its directory.
+func (s Settings) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func clamp[T int32 | float32](v, min, max T) T {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
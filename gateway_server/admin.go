@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close code sent to kicked clients, the rate limit ones are in the
+// ratelimit package. 4000-4999 are free for application use.
+const closeKicked = 4001 // removed by an operator
+
+type adminMember struct {
+	PlayerID string    `json:"player_id"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type adminLobby struct {
+	ID            string        `json:"id"`
+	CreatedAt     time.Time     `json:"created_at"`
+	HostConnected bool          `json:"host_connected"`
+	SessionMap    string        `json:"session_map,omitempty"`
+	Members       []adminMember `json:"members"`
+}
+
+func setupAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/lobbies", requireAdmin(adminListLobbies))
+	mux.HandleFunc("POST /admin/lobbies", requireAdmin(adminCreateLobby))
+	mux.HandleFunc("GET /admin/lobbies/{id}", requireAdmin(adminGetLobby))
+	mux.HandleFunc("DELETE /admin/lobbies/{id}", requireAdmin(adminCloseLobby))
+	mux.HandleFunc("DELETE /admin/lobbies/{id}/players/{player}", requireAdmin(adminKickPlayer))
+	mux.HandleFunc("POST /admin/broadcast", requireAdmin(adminBroadcast))
+}
+
+// requireAdmin only lets requests through that carry the configured admin
+// token as "Authorization: Bearer <token>".
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing admin response: %v", err)
+	}
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
+
+func describeLobby(id string, lobby *Lobby) adminLobby {
+	info := adminLobby{
+		ID:            id,
+		CreatedAt:     lobby.CreatedAt,
+		HostConnected: lobby.getHost() != nil,
+		SessionMap:    lobby.Settings.SessionMap,
+		Members:       []adminMember{},
+	}
+	lobby.clientsMutex.RLock()
+	for _, playerID := range lobby.clientOrder {
+		info.Members = append(info.Members, adminMember{
+			PlayerID: playerID,
+			JoinedAt: lobby.joinedAt[playerID],
+		})
+	}
+	lobby.clientsMutex.RUnlock()
+	return info
+}
+
+func adminListLobbies(w http.ResponseWriter, r *http.Request) {
+	lobbiesMutex.RLock()
+	list := make([]adminLobby, 0, len(lobbies))
+	for id, lobby := range lobbies {
+		list = append(list, describeLobby(id, lobby))
+	}
+	lobbiesMutex.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	writeAdminJSON(w, http.StatusOK, list)
+}
+
+// adminCreateLobby opens a lobby hosted by the gateway on the given map.
+func adminCreateLobby(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Map string `json:"map"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Map == "" {
+		writeAdminError(w, http.StatusBadRequest, "expected JSON body with a map")
+		return
+	}
+	id, err := createSessionLobby(request.Map)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lobbiesMutex.RLock()
+	lobby := lobbies[id]
+	lobbiesMutex.RUnlock()
+	writeAdminJSON(w, http.StatusCreated, describeLobby(id, lobby))
+}
+
+func adminGetLobby(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	lobbiesMutex.RLock()
+	lobby, exists := lobbies[id]
+	lobbiesMutex.RUnlock()
+	if !exists {
+		writeAdminError(w, http.StatusNotFound, "lobby not found")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, describeLobby(id, lobby))
+}
+
+func adminCloseLobby(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	lobbiesMutex.RLock()
+	lobby, exists := lobbies[id]
+	lobbiesMutex.RUnlock()
+	if !exists {
+		writeAdminError(w, http.StatusNotFound, "lobby not found")
+		return
+	}
+
+	closeLobby(id)
+	if host := lobby.getHost(); host != nil {
+		host.CloseWithReason(websocket.CloseNormalClosure, "lobby closed by admin")
+	}
+	log.Printf("Admin closed lobby %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminKickPlayer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	playerID := r.PathValue("player")
+	lobbiesMutex.RLock()
+	lobby, exists := lobbies[id]
+	lobbiesMutex.RUnlock()
+	if !exists {
+		writeAdminError(w, http.StatusNotFound, "lobby not found")
+		return
+	}
+
+	lobby.clientsMutex.RLock()
+	conn, found := lobby.Clients[playerID]
+	lobby.clientsMutex.RUnlock()
+	if !found {
+		writeAdminError(w, http.StatusNotFound, "player not found")
+		return
+	}
+
+	msg, _ := json.Marshal(map[string]string{
+		"type":   "kicked",
+		"reason": "kicked by admin",
+	})
+	conn.WriteMessage(websocket.TextMessage, msg)
+	conn.CloseWithReason(closeKicked, "kicked by admin")
+	lobby.removeClient(playerID)
+	log.Printf("Admin kicked player %s from lobby %s", playerID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminBroadcast sends a system message to every player, or only to the
+// players of one lobby if lobby_id is given.
+func adminBroadcast(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		LobbyID string `json:"lobby_id"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Message == "" {
+		writeAdminError(w, http.StatusBadRequest, "expected JSON body with a message")
+		return
+	}
+
+	msg, err := json.Marshal(map[string]string{
+		"type":    "system_message",
+		"message": request.Message,
+	})
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	lobbiesMutex.RLock()
+	var targets []*Lobby
+	for id, lobby := range lobbies {
+		if request.LobbyID == "" || request.LobbyID == id {
+			targets = append(targets, lobby)
+		}
+	}
+	lobbiesMutex.RUnlock()
+	if request.LobbyID != "" && len(targets) == 0 {
+		writeAdminError(w, http.StatusNotFound, "lobby not found")
+		return
+	}
+
+	sent := 0
+	for _, lobby := range targets {
+		lobby.clientsMutex.RLock()
+		for clientID, clientConn := range lobby.Clients {
+			if err := clientConn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("Error sending system message to client %s: %v", clientID, err)
+				continue
+			}
+			sent++
+		}
+		lobby.clientsMutex.RUnlock()
+	}
+	fmt.Printf("Admin broadcast to %d players: %s\n", sent, request.Message)
+	writeAdminJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
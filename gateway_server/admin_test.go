@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, base, method, path, token string) *http.Response {
+	t.Helper()
+	request, _ := http.NewRequest(method, "http"+strings.TrimPrefix(base, "ws")+path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func TestAdminListLobbies(t *testing.T) {
+	base := startGateway(t)
+	_, lobbyID := registerHost(t, base, false)
+	_, playerID := joinLobby(t, base, lobbyID)
+
+	tests := []struct {
+		name    string
+		token   string
+		status  int
+		members []string
+	}{
+		{"no token", "", http.StatusUnauthorized, nil},
+		{"wrong token", "wrong", http.StatusUnauthorized, nil},
+		{"admin", testAdminToken, http.StatusOK, []string{playerID}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := adminRequest(t, base, "GET", "/admin/lobbies", test.token)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var list []adminLobby
+			if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 1 || list[0].ID != lobbyID || !list[0].HostConnected {
+				t.Fatalf("got %+v, want lobby %s with its host", list, lobbyID)
+			}
+			if len(list[0].Members) != len(test.members) || list[0].Members[0].PlayerID != test.members[0] {
+				t.Fatalf("members %+v, want %v", list[0].Members, test.members)
+			}
+		})
+	}
+}
+
+func TestAdminKickPlayer(t *testing.T) {
+	tests := []struct {
+		name   string
+		lobby  func(lobbyID string) string
+		player func(playerID string) string
+		token  string
+		status int
+	}{
+		{"kick", same, same, testAdminToken, http.StatusNoContent},
+		{"no token", same, same, "", http.StatusUnauthorized},
+		{"unknown lobby", other, same, testAdminToken, http.StatusNotFound},
+		{"unknown player", same, other, testAdminToken, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := startGateway(t)
+			host, lobbyID := registerHost(t, base, false)
+			player, playerID := joinLobby(t, base, lobbyID)
+
+			path := "/admin/lobbies/" + test.lobby(lobbyID) + "/players/" + test.player(playerID)
+			response := adminRequest(t, base, "DELETE", path, test.token)
+			if response.StatusCode != test.status {
+				t.Fatalf("status %d, want %d", response.StatusCode, test.status)
+			}
+			if test.status != http.StatusNoContent {
+				return
+			}
+
+			receive(t, player, hasField("type", "kicked"))
+			// The host has to drop the player from its world as well
+			left := receive(t, host, hasField("command", "player_left"))
+			if got := getStringValue(left, "player_id"); got != playerID {
+				t.Fatalf("player_left for %s, want %s", got, playerID)
+			}
+			lobbiesMutex.RLock()
+			lobby := lobbies[lobbyID]
+			lobbiesMutex.RUnlock()
+			if len(describeLobby(lobbyID, lobby).Members) != 0 {
+				t.Fatal("kicked player is still a member")
+			}
+		})
+	}
+}
+
+func TestPlayerLeftOnDisconnect(t *testing.T) {
+	base := startGateway(t)
+	host, lobbyID := registerHost(t, base, false)
+	player, playerID := joinLobby(t, base, lobbyID)
+
+	player.Close()
+	left := receive(t, host, hasField("command", "player_left"))
+	if got := getStringValue(left, "player_id"); got != playerID {
+		t.Fatalf("player_left for %s, want %s", got, playerID)
+	}
+}
+
+func same(id string) string  { return id }
+func other(id string) string { return "missing-" + id }
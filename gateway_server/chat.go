@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gorilla/websocket"
+)
+
+// chat sends a player's message to everyone in the lobby, or returns the
+// error to answer the player with.
+func (s *Session) chat(playerID string, data map[string]interface{}) map[string]string {
+	s.mutex.Lock()
+	_, spawned := s.players[playerID]
+	if !spawned {
+		s.mutex.Unlock()
+		return nil
+	}
+	text, err := s.chatFlood.Filter(playerID, getStringValue(data, "text"))
+	name := s.names[playerID]
+	s.mutex.Unlock()
+	if err != nil {
+		return map[string]string{
+			"type":      "chat_error",
+			"error":     err.Error(),
+			"player_id": playerID,
+		}
+	}
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":      "chat",
+		"broadcast": true,
+		"player_id": playerID,
+		"name":      name,
+		"text":      text,
+	})
+	if err != nil {
+		log.Printf("Error marshalling chat message: %v", err)
+		return nil
+	}
+	if s.broadcast != nil {
+		s.broadcast("chat", msg)
+	}
+	return nil
+}
+
+// broadcast sends a message to every player of the lobby.
+func (l *Lobby) broadcast(label string, msg []byte) {
+	l.clientsMutex.RLock()
+	clients := make(map[string]*SafeConnection, len(l.Clients))
+	for clientID, clientConn := range l.Clients {
+		clients[clientID] = clientConn
+	}
+	l.clientsMutex.RUnlock()
+
+	for clientID, clientConn := range clients {
+		err := clientConn.WriteMessage(websocket.TextMessage, msg)
+		recordForward(label, len(msg), err)
+		if err != nil {
+			log.Printf("Error sending message to client %s: %v", clientID, err)
+			l.removeClient(clientID)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+// chunks returns the requested chunks of the player's map that it wasn't
+// sent yet. Chunks in "forget" were dropped by the client.
+func (s *Session) chunks(playerID string, data map[string]interface{}) []map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sentChunks.Request(playerID, s.playerLevel(playerID), getStringValue(data, "chunks"), getStringValue(data, "forget"))
+}
+
+// checkChunks clears what a player was sent if the map it has is not the
+// map it is on.
+func (s *Session) checkChunks(playerID string, data map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sentChunks.Check(playerID, s.playerLevel(playerID), getStringValue(data, "map"), getStringValue(data, "version"))
+}
+
+func (s *Session) forgetChunks(playerID string) {
+	s.mutex.Lock()
+	delete(s.sentChunks, playerID)
+	s.mutex.Unlock()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"main/ratelimit"
+)
+
+// Config holds the gateway settings. Values are read from the JSON file given
+// with -config, command line flags override them.
+type Config struct {
+	AdminToken       string   `json:"admin_token"`
+	HostMigration    bool     `json:"host_migration"`
+	MigrationTimeout Duration `json:"migration_timeout"`
+
+	// Maximum size of a single message in bytes
+	ClientReadLimit int64 `json:"client_read_limit"`
+	HostReadLimit   int64 `json:"host_read_limit"`
+
+	ClientLimits ratelimit.Config `json:"client_limits"`
+	HostLimits   ratelimit.Config `json:"host_limits"`
+
+	// Origins browsers may connect from, "*" allows all. Requests without
+	// an Origin header (like the game client) and same origin requests are
+	// always allowed.
+	AllowedOrigins []string `json:"allowed_origins"`
+
+	// Lobbies are kept in StoreFile so hosts and players can reclaim them
+	// within ResumeGrace after a gateway restart, "" disables this
+	StoreFile   string   `json:"store_file"`
+	ResumeGrace Duration `json:"resume_grace"`
+
+	// Directory with the maps of gateway hosted lobbies, the item kinds
+	// they use and the tilesets their maps are autotiled with
+	MapDir       string `json:"map_dir"`
+	ItemsFile    string `json:"items_file"`
+	TilesetsFile string `json:"tilesets_file"`
+
+	// Serve wss:// instead of ws:// when both are set
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+}
+
+// Duration is a time.Duration written as a string like "10s" in the config.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func defaultConfig() Config {
+	return Config{
+		MigrationTimeout: Duration{10 * time.Second},
+		ResumeGrace:      Duration{2 * time.Minute},
+		MapDir:           "../resource/maps",
+		ItemsFile:        "../resource/items.json",
+		TilesetsFile:     "../resource/tilesets/tilesets.json",
+		ClientReadLimit:  4 * 1024,
+		HostReadLimit:    1024 * 1024,
+		ClientLimits: ratelimit.Config{
+			Connection: ratelimit.Limit{Rate: 100, Burst: 200},
+			Commands: map[string]ratelimit.Limit{
+				"registerPlayer": {Rate: 1, Burst: 3},
+				"respawn":        {Rate: 1, Burst: 3},
+				"get_map":        {Rate: 2, Burst: 5},
+				"get_chunks":     {Rate: 10, Burst: 20},
+				"get_players":    {Rate: 30, Burst: 60},
+				"player_data":    {Rate: 70, Burst: 120},
+				"chat":           {Rate: 2, Burst: 10},
+				"farm":           {Rate: 5, Burst: 10},
+				"get_crops":      {Rate: 2, Burst: 5},
+				"drop":           {Rate: 5, Burst: 10},
+				"move_item":      {Rate: 5, Burst: 10},
+				"toggle_door":    {Rate: 5, Burst: 10},
+			},
+			MaxStrikes: 200,
+			MaxInvalid: 20,
+		},
+		// Hosts answer every player, so they get much more than a client
+		HostLimits: ratelimit.Config{
+			Connection: ratelimit.Limit{Rate: 2000, Burst: 4000},
+			Commands: map[string]ratelimit.Limit{
+				"registerHost": {Rate: 1, Burst: 3},
+				"resumeHost":   {Rate: 1, Burst: 3},
+				"hostPlayer":   {Rate: 1, Burst: 3},
+			},
+			MaxStrikes: 1000,
+			MaxInvalid: 20,
+		},
+	}
+}
+
+func loadConfig(path string, cfg *Config) error {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(file, cfg); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseConfig parses the command line and fills the global config.
+func parseConfig() error {
+	configFile := flag.String("config", "", "path to a JSON config file")
+	hostMigration := flag.Bool("host-migration", false, "elect a new host instead of closing the lobby when the host disconnects")
+	migrationTimeout := flag.Duration("migration-timeout", config.MigrationTimeout.Duration, "how long an elected host has to take over the lobby")
+	allowedOrigins := flag.String("allowed-origins", "", "comma separated list of origins browsers may connect from, * allows all")
+	storeFile := flag.String("store", "", "file to keep lobbies in across restarts")
+	resumeGrace := flag.Duration("resume-grace", config.ResumeGrace.Duration, "how long restored lobbies wait for their host")
+	mapDir := flag.String("map-dir", config.MapDir, "directory with the maps of gateway hosted lobbies")
+	itemsFile := flag.String("items-file", config.ItemsFile, "item kinds of gateway hosted lobbies")
+	tilesetsFile := flag.String("tilesets-file", config.TilesetsFile, "tilesets the maps of gateway hosted lobbies are autotiled with")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file, enables wss://")
+	tlsKey := flag.String("tls-key", "", "TLS key file")
+	flag.Parse()
+
+	if *configFile != "" {
+		if err := loadConfig(*configFile, &config); err != nil {
+			return err
+		}
+	}
+
+	// Only flags given on the command line override the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "host-migration":
+			config.HostMigration = *hostMigration
+		case "migration-timeout":
+			config.MigrationTimeout.Duration = *migrationTimeout
+		case "allowed-origins":
+			config.AllowedOrigins = strings.Split(*allowedOrigins, ",")
+		case "store":
+			config.StoreFile = *storeFile
+		case "resume-grace":
+			config.ResumeGrace.Duration = *resumeGrace
+		case "map-dir":
+			config.MapDir = *mapDir
+		case "items-file":
+			config.ItemsFile = *itemsFile
+		case "tilesets-file":
+			config.TilesetsFile = *tilesetsFile
+		case "tls-cert":
+			config.TLSCert = *tlsCert
+		case "tls-key":
+			config.TLSKey = *tlsKey
+		}
+	})
+
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"main/sim"
+)
+
+// toggleDoor opens or closes a door next to a session player.
+func (s *Session) toggleDoor(playerID string, data map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	player, exists := s.players[playerID]
+	if !exists {
+		return fmt.Errorf("not spawned")
+	}
+	l := s.playerLevel(playerID)
+	tile, err := strconv.Atoi(getStringValue(data, "tile"))
+	if err != nil {
+		return fmt.Errorf("no door there")
+	}
+	var others []sim.Rect
+	for id, other := range s.players {
+		if s.playerLevel(id) == l {
+			others = append(others, other["playerDest"])
+		}
+	}
+	return l.ToggleDoor(tile, player["playerDest"], others)
+}
+
+func (s *Session) doorsResponse(playerID string) map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	l := s.playerLevel(playerID)
+	return map[string]interface{}{
+		"type":      "doors",
+		"map":       l.Name,
+		"open":      l.OpenDoors(),
+		"player_id": playerID,
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"main/sim"
+)
+
+// farm plants the seeds in a slot, waters or harvests the crop on a tile
+// for a session player.
+func (s *Session) farm(playerID string, data map[string]interface{}) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	player, exists := s.players[playerID]
+	if !exists {
+		return fmt.Errorf("not spawned")
+	}
+	tile, err := strconv.Atoi(getStringValue(data, "tile"))
+	if err != nil {
+		return fmt.Errorf("nothing to farm there")
+	}
+	slot, err := strconv.Atoi(getStringValue(data, "slot"))
+	if err != nil {
+		slot = -1
+	}
+	return s.world.Farm(s.playerLevel(playerID), playerID, player["playerDest"], tile, slot)
+}
+
+func (s *Session) cropsResponse(playerID string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.playerLevel(playerID)
+	grown := l.GrownCrops(time.Now())
+	crops := make(map[int]sim.Crop, len(grown))
+	for tile, crop := range grown {
+		crops[tile] = *crop
+	}
+	return map[string]interface{}{
+		"type":      "crops",
+		"map":       l.Name,
+		"crops":     crops,
+		"player_id": playerID,
+	}
+}
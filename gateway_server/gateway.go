@@ -1,28 +1,57 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+
+	"main/autotile"
+	"main/ratelimit"
+	"main/sim"
 )
 
 var (
 	lobbies      = make(map[string]*Lobby)
 	lobbiesMutex sync.RWMutex // Mutex für die lobbies map
+
+	config = defaultConfig()
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
+	CheckOrigin:     checkOrigin,
+}
+
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.AllowedOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	log.Printf("Rejected connection from origin %s", origin)
+	return false
 }
 
 type SafeConnection struct {
@@ -43,44 +72,184 @@ func (sc *SafeConnection) WriteMessage(messageType int, data []byte) error {
 	return sc.conn.WriteMessage(messageType, data)
 }
 
+func (sc *SafeConnection) SetReadLimit(limit int64) {
+	sc.conn.SetReadLimit(limit)
+}
+
 func (sc *SafeConnection) ReadMessage() (int, []byte, error) {
 	sc.readMutex.Lock()
 	defer sc.readMutex.Unlock()
 	return sc.conn.ReadMessage()
 }
 
+// CloseWithReason sends a close frame with the given code before closing.
+func (sc *SafeConnection) CloseWithReason(code int, reason string) error {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+	msg := websocket.FormatCloseMessage(code, reason)
+	sc.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return sc.conn.Close()
+}
+
 func (sc *SafeConnection) Close() error {
 	sc.writeMutex.Lock()
 	defer sc.writeMutex.Unlock()
 	return sc.conn.Close()
 }
 
+// LobbySettings are chosen by the host when creating the lobby.
+type LobbySettings struct {
+	HostMigration bool   `json:"host_migration"`
+	SessionMap    string `json:"session_map,omitempty"` // Map of a gateway hosted lobby
+}
+
 type Lobby struct {
+	InviteCode   string
+	CreatedAt    time.Time
+	Settings     LobbySettings
 	Host         *SafeConnection
 	HostPlayerID string // Add this to track host's player ID
+	HostToken    string // Secret the host needs to take the lobby over again
+	hostClientID string // The host's own player, joined like the others but never elected
+	hostMutex    sync.RWMutex
 	Clients      map[string]*SafeConnection
+	clientOrder  []string // Player IDs in join order, used for host election
+	joinedAt     map[string]time.Time
+	playerKey    string       // Signs the tokens players reclaim their ID with
 	clientsMutex sync.RWMutex // Mutex für die clients map
+
+	snapshot *Snapshot // Last known world state, used for host migration
+
+	Session *Session // Set if the gateway runs the game itself, there is no host then
 }
 
 func NewLobby(host *SafeConnection) *Lobby {
 	return &Lobby{
-		Host:    host,
-		Clients: make(map[string]*SafeConnection),
+		CreatedAt: time.Now(),
+		Host:      host,
+		HostToken: uuid.New().String(),
+		Settings: LobbySettings{
+			HostMigration: config.HostMigration,
+		},
+		Clients:   make(map[string]*SafeConnection),
+		joinedAt:  make(map[string]time.Time),
+		playerKey: uuid.New().String(),
+		snapshot:  NewSnapshot(),
+	}
+}
+
+// addClient adds a connected player and returns the token the player can
+// reclaim its ID with after a gateway restart.
+func (l *Lobby) addClient(playerID string, conn *SafeConnection) string {
+	l.clientsMutex.Lock()
+	l.Clients[playerID] = conn
+	l.clientOrder = append(l.clientOrder, playerID)
+	l.joinedAt[playerID] = time.Now()
+	l.clientsMutex.Unlock()
+	return l.resumeToken(playerID)
+}
+
+// resumeToken returns the token of a player, derived from the lobby's key so
+// the store doesn't change when players join and leave.
+func (l *Lobby) resumeToken(playerID string) string {
+	mac := hmac.New(sha256.New, []byte(l.playerKey))
+	mac.Write([]byte(playerID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// reclaimPlayer reports whether a reconnecting player may keep its old ID.
+func (l *Lobby) reclaimPlayer(playerID string, token string) bool {
+	l.clientsMutex.RLock()
+	defer l.clientsMutex.RUnlock()
+	if _, connected := l.Clients[playerID]; connected {
+		return false
+	}
+	return token != "" && hmac.Equal([]byte(token), []byte(l.resumeToken(playerID)))
+}
+
+// removeClient removes a player that disconnected or was kicked and tells
+// the host, which keeps the player in its world until then.
+func (l *Lobby) removeClient(playerID string) {
+	l.clientsMutex.Lock()
+	_, connected := l.Clients[playerID]
+	delete(l.Clients, playerID)
+	delete(l.joinedAt, playerID)
+	for i, id := range l.clientOrder {
+		if id == playerID {
+			l.clientOrder = append(l.clientOrder[:i], l.clientOrder[i+1:]...)
+			break
+		}
+	}
+	l.clientsMutex.Unlock()
+	l.snapshot.removePlayer(playerID)
+	if l.Session != nil {
+		l.Session.removePlayer(playerID)
+	}
+	if host := l.getHost(); connected && host != nil {
+		msg, _ := json.Marshal(map[string]string{
+			"command":   "player_left",
+			"player_id": playerID,
+		})
+		err := host.WriteMessage(websocket.TextMessage, msg)
+		recordForward("player_left", len(msg), err)
 	}
 }
 
 func main() {
-	setupRoutes()
-	log.Printf("Server starting on port %s", os.Args[1])
-	http.ListenAndServe("0.0.0.0:"+os.Args[1], nil)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <port>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := parseConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	port := flag.Arg(0)
+
+	if err := sim.LoadItems(config.ItemsFile); err != nil {
+		// Only gateway hosted lobbies need them
+		log.Printf("Cannot load items: %v", err)
+	}
+	if rules, err := autotile.Load(config.TilesetsFile); err != nil {
+		log.Printf("Maps are not autotiled: %v", err)
+	} else {
+		autotileRules = rules
+	}
+	if err := restoreLobbies(); err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	setupRoutes(mux)
+	var err error
+	if config.TLSCert != "" {
+		log.Printf("Server starting on port %s (TLS)", port)
+		err = http.ListenAndServeTLS("0.0.0.0:"+port, config.TLSCert, config.TLSKey, mux)
+	} else {
+		log.Printf("Server starting on port %s", port)
+		err = http.ListenAndServe("0.0.0.0:"+port, mux)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func setupRoutes() {
-	http.HandleFunc("/host", hostHandler)
-	http.HandleFunc("/join", joinHandler)
+func setupRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/host", hostHandler)
+	mux.HandleFunc("/join", joinHandler)
+	setupMetricsRoutes(mux)
+
+	if config.AdminToken != "" {
+		setupAdminRoutes(mux)
+	} else {
+		log.Println("No admin_token configured, admin API disabled")
+	}
 }
 
 func closeLobby(lobby_id string) {
+	defer saveLobbies()
 	lobbiesMutex.Lock()
 	defer lobbiesMutex.Unlock()
 
@@ -115,6 +284,8 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 
 	safeConn := NewSafeConnection(conn)
 	defer safeConn.Close()
+	safeConn.SetReadLimit(config.HostReadLimit)
+	limiter := ratelimit.NewConn(config.HostLimits)
 	log.Println("Host WebSocket connection established")
 	var lobbyID string
 
@@ -136,11 +307,23 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("JSON unmarshal error: %v", err)
 			log.Printf("Raw message: %s", string(message))
+			limiter.Malformed()
+			if code := limiter.CloseCode(config.HostLimits); code != 0 {
+				log.Printf("Disconnecting host of lobby %s: too many malformed messages", lobbyID)
+				safeConn.CloseWithReason(code, "too many malformed messages")
+			}
 			continue
 		}
 
 		command := getStringValue(data, "command")
 		playerID := getStringValue(data, "player_id")
+		if !limiter.Allow(command) {
+			if code := limiter.CloseCode(config.HostLimits); code != 0 {
+				log.Printf("Disconnecting host of lobby %s: rate limit exceeded", lobbyID)
+				safeConn.CloseWithReason(code, "rate limit exceeded")
+			}
+			continue
+		}
 
 		switch command {
 		case "registerHost":
@@ -148,16 +331,25 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 			lobbyID = id.String()
 
 			// Create the lobby BEFORE trying to access it
+			lobby := NewLobby(safeConn)
+			lobby.InviteCode = lobbyID
+			lobby.HostPlayerID = "" // Will be set when host registers as player
+			if migration := getStringValue(data, "host_migration"); migration != "" {
+				lobby.Settings.HostMigration = migration == "true"
+			}
+			hostToken := lobby.HostToken
+
 			lobbiesMutex.Lock()
-			lobbies[lobbyID] = NewLobby(safeConn)
-			lobbies[lobbyID].HostPlayerID = "" // Will be set when host registers as player
+			lobbies[lobbyID] = lobby
 			lobbiesMutex.Unlock()
+			saveLobbies()
 
 			fmt.Println("Lobby created:", lobbyID)
 
 			response_data := map[string]string{
-				"command":  "registerHostResponse",
-				"lobby_id": lobbyID,
+				"command":    "registerHostResponse",
+				"lobby_id":   lobbyID,
+				"host_token": hostToken,
 			}
 
 			msg, err := json.Marshal(response_data)
@@ -167,12 +359,54 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 			// Set up cleanup when connection closes
-			defer closeLobby(lobbyID)
+			defer hostDisconnected(lobbyID, safeConn)
 
 			if err := safeConn.WriteMessage(websocket.TextMessage, msg); err != nil {
 				log.Printf("Error writing message: %v", err)
 			}
 
+		case "resumeHost":
+			// A host (e.g. an elected client after migration) takes over an existing lobby
+			requestedID := getStringValue(data, "lobby_id")
+			if !resumeHost(requestedID, getStringValue(data, "host_token"), safeConn) {
+				errorResponse := map[string]string{
+					"type":  "error",
+					"error": "Cannot resume lobby",
+				}
+				errorMsg, _ := json.Marshal(errorResponse)
+				safeConn.WriteMessage(websocket.TextMessage, errorMsg)
+				continue
+			}
+			lobbyID = requestedID
+			defer hostDisconnected(lobbyID, safeConn)
+
+			response_data := map[string]string{
+				"command":  "resumeHostResponse",
+				"lobby_id": lobbyID,
+			}
+			msg, _ := json.Marshal(response_data)
+			safeConn.WriteMessage(websocket.TextMessage, msg)
+
+		case "world":
+			// All maps of the host's world, kept for host migration
+			lobbiesMutex.RLock()
+			lobby, exists := lobbies[lobbyID]
+			lobbiesMutex.RUnlock()
+			if exists {
+				lobby.snapshot.setWorld(data)
+			}
+
+		case "hostPlayer":
+			// The player the host's game joined its own lobby with
+			lobbiesMutex.RLock()
+			lobby, exists := lobbies[lobbyID]
+			lobbiesMutex.RUnlock()
+			if exists && lobby.getHost() == safeConn {
+				lobby.hostMutex.Lock()
+				lobby.hostClientID = playerID
+				lobby.hostMutex.Unlock()
+			}
+
 		case "registerPlayer":
 			// Host is registering as a player in their own lobby
 			lobbiesMutex.RLock()
@@ -193,6 +427,9 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 			}
 
 		default:
+			start := time.Now()
+			label := commandLabel(data)
+
 			// Forward message to clients only, but not back to host
 			if playerID != "" {
 				lobbiesMutex.RLock()
@@ -200,18 +437,27 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 				lobbiesMutex.RUnlock()
 
 				if lobbyExists {
+					lobby.snapshot.update(data)
+
+					// Chat and other messages for everyone, player_id is the sender
+					if broadcast, _ := data["broadcast"].(bool); broadcast {
+						lobby.broadcast(label, message)
+						observeForward(start)
+						continue
+					}
+
 					// Don't forward messages from host back to host
 					if playerID == lobby.HostPlayerID {
 						// This is a message from the host, forward only to clients
 						lobby.clientsMutex.RLock()
 						for clientID, clientConn := range lobby.Clients {
-							if err := clientConn.WriteMessage(websocket.TextMessage, message); err != nil {
+							err := clientConn.WriteMessage(websocket.TextMessage, message)
+							recordForward(label, len(message), err)
+							if err != nil {
 								log.Printf("Error forwarding message to client %s: %v", clientID, err)
 								// Remove disconnected client
 								lobby.clientsMutex.RUnlock()
-								lobby.clientsMutex.Lock()
-								delete(lobby.Clients, clientID)
-								lobby.clientsMutex.Unlock()
+								lobby.removeClient(clientID)
 								lobby.clientsMutex.RLock()
 							}
 						}
@@ -224,12 +470,12 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 
 						if clientExists {
 							// Forward the original message as-is
-							if err := clientConn.WriteMessage(websocket.TextMessage, message); err != nil {
+							err := clientConn.WriteMessage(websocket.TextMessage, message)
+							recordForward(label, len(message), err)
+							if err != nil {
 								log.Printf("Error forwarding message to client %s: %v", playerID, err)
 								// Remove disconnected client
-								lobby.clientsMutex.Lock()
-								delete(lobby.Clients, playerID)
-								lobby.clientsMutex.Unlock()
+								lobby.removeClient(playerID)
 							}
 						} else {
 							log.Printf("Client %s not found in lobby %s", playerID, lobbyID)
@@ -241,6 +487,7 @@ func hostHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				log.Printf("Received message without player_id: %s", string(message))
 			}
+			observeForward(start)
 		}
 	}
 }
@@ -254,6 +501,9 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 
 	safeConn := NewSafeConnection(conn)
 	defer safeConn.Close()
+	safeConn.SetReadLimit(config.ClientReadLimit)
+	limiter := ratelimit.NewConn(config.ClientLimits)
+	var lastThrottleNotice time.Time
 	log.Println("Client WebSocket connection established")
 
 	var playerID string = ""
@@ -275,9 +525,7 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 				lobbiesMutex.RUnlock()
 
 				if exists {
-					lobby.clientsMutex.Lock()
-					delete(lobby.Clients, playerID)
-					lobby.clientsMutex.Unlock()
+					lobby.removeClient(playerID)
 					fmt.Printf("Player %s removed from lobby %s\n", playerID, playerLobbyID)
 				}
 			}
@@ -290,10 +538,32 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			log.Printf("JSON unmarshal error: %v", err)
 			log.Printf("Raw message: %s", string(message))
+			limiter.Malformed()
+			if code := limiter.CloseCode(config.ClientLimits); code != 0 {
+				log.Printf("Disconnecting client %s: too many malformed messages", playerID)
+				safeConn.CloseWithReason(code, "too many malformed messages")
+			}
 			continue
 		}
 
-		command := data["command"]
+		command := getStringValue(data, "command")
+		if !limiter.Allow(command) {
+			if code := limiter.CloseCode(config.ClientLimits); code != 0 {
+				log.Printf("Disconnecting client %s: rate limit exceeded", playerID)
+				safeConn.CloseWithReason(code, "rate limit exceeded")
+				continue
+			}
+			// Tell the client at most once per second that it is throttled
+			if time.Since(lastThrottleNotice) > time.Second {
+				lastThrottleNotice = time.Now()
+				notice, _ := json.Marshal(map[string]string{
+					"type":    "throttled",
+					"command": command,
+				})
+				safeConn.WriteMessage(websocket.TextMessage, notice)
+			}
+			continue
+		}
 
 		switch command {
 		case "registerPlayer":
@@ -320,17 +590,24 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 				continue
 			}
 
-			id := uuid.New()
-			playerID = id.String()
+			// Players coming back after a gateway restart keep their ID
+			playerID = ""
+			if previousID := getStringValue(data, "player_id"); previousID != "" {
+				if lobby.reclaimPlayer(previousID, getStringValue(data, "resume_token")) {
+					playerID = previousID
+				}
+			}
+			if playerID == "" {
+				playerID = uuid.New().String()
+			}
 			playerLobbyID = inviteCode
 
-			lobby.clientsMutex.Lock()
-			lobby.Clients[playerID] = safeConn
-			lobby.clientsMutex.Unlock()
+			resumeToken := lobby.addClient(playerID, safeConn)
 
 			response_data := map[string]string{
-				"type":      "player_id",
-				"player_id": playerID,
+				"type":         "player_id",
+				"player_id":    playerID,
+				"resume_token": resumeToken,
 			}
 
 			msg, err := json.Marshal(response_data)
@@ -343,6 +620,7 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 			safeConn.WriteMessage(websocket.TextMessage, msg)
 
 		default:
+			start := time.Now()
 			if playerID == "" || playerLobbyID == "" {
 				//log.Printf("Message received before player registration: %s", string(message))
 				continue
@@ -362,8 +640,21 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 			lobby, exists := lobbies[playerLobbyID]
 			lobbiesMutex.RUnlock()
 
-			if exists && lobby.Host != nil {
-				if err := lobby.Host.WriteMessage(websocket.TextMessage, msg); err != nil {
+			if exists && lobby.Session != nil {
+				lobby.Session.handle(playerID, data, safeConn)
+				sessionMessages.WithLabelValues(commandLabel(data)).Inc()
+				observeForward(start)
+				continue
+			}
+
+			var host *SafeConnection
+			if exists {
+				host = lobby.getHost()
+			}
+			if host != nil {
+				err := host.WriteMessage(websocket.TextMessage, msg)
+				recordForward(commandLabel(data), len(msg), err)
+				if err != nil {
 					log.Printf("Error forwarding message to host: %v", err)
 				} else {
 					//if command != "get_players" {
@@ -371,8 +662,10 @@ func joinHandler(w http.ResponseWriter, r *http.Request) {
 					//}
 				}
 			} else {
+				forwardErrors.WithLabelValues(commandLabel(data)).Inc()
 				log.Printf("Host not found for lobby %s", playerLobbyID)
 			}
+			observeForward(start)
 		}
 	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testAdminToken = "test-admin-token"
+
+// startGateway serves all routes with a fresh lobby list and the default
+// config plus an admin token, and returns the ws:// base URL.
+func startGateway(t *testing.T) string {
+	t.Helper()
+	resetGateway(t)
+	config.AdminToken = testAdminToken
+	mux := http.NewServeMux()
+	setupRoutes(mux)
+	// Closing the server leaves websocket handlers running, wait for them
+	// before the next test resets the lobbies and config
+	var handlers sync.WaitGroup
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Add(1)
+		defer handlers.Done()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(handlers.Wait)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// resetGateway gives a test its own lobbies and config.
+func resetGateway(t *testing.T) {
+	t.Helper()
+	lobbiesMutex.Lock()
+	lobbies = make(map[string]*Lobby)
+	lobbiesMutex.Unlock()
+	config = defaultConfig()
+	t.Cleanup(func() {
+		lobbiesMutex.Lock()
+		lobbies = make(map[string]*Lobby)
+		lobbiesMutex.Unlock()
+		config = defaultConfig()
+	})
+}
+
+func dialGateway(t *testing.T, base, path string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(base+path, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg map[string]interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(msg)
+	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatalf("sending %v: %v", msg, err)
+	}
+}
+
+// receive reads messages until one matches, or fails the test after a
+// few seconds.
+func receive(t *testing.T, conn *websocket.Conn, match func(map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for a message: %v", err)
+		}
+		var msg map[string]interface{}
+		if json.Unmarshal(data, &msg) == nil && match(msg) {
+			return msg
+		}
+	}
+}
+
+func hasField(key, value string) func(map[string]interface{}) bool {
+	return func(msg map[string]interface{}) bool {
+		return getStringValue(msg, key) == value
+	}
+}
+
+// registerHost opens a lobby and returns the host connection and lobby ID.
+func registerHost(t *testing.T, base string, migration bool) (*websocket.Conn, string) {
+	t.Helper()
+	host := dialGateway(t, base, "/host")
+	send(t, host, map[string]interface{}{
+		"command":        "registerHost",
+		"host_migration": map[bool]string{true: "true", false: "false"}[migration],
+	})
+	response := receive(t, host, hasField("command", "registerHostResponse"))
+	return host, getStringValue(response, "lobby_id")
+}
+
+// joinLobby registers a player and returns its connection and player ID.
+func joinLobby(t *testing.T, base, lobbyID string) (*websocket.Conn, string) {
+	t.Helper()
+	conn := dialGateway(t, base, "/join")
+	send(t, conn, map[string]interface{}{"command": "registerPlayer", "invite_code": lobbyID})
+	response := receive(t, conn, hasField("type", "player_id"))
+	return conn, getStringValue(response, "player_id")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"main/sim"
+)
+
+// drop drops items of a slot at the player's feet.
+func (s *Session) drop(playerID string, data map[string]interface{}) error {
+	slot, _ := strconv.Atoi(getStringValue(data, "slot"))
+	count, err := strconv.Atoi(getStringValue(data, "count"))
+	if err != nil {
+		count = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	player, exists := s.players[playerID]
+	if !exists {
+		return fmt.Errorf("not spawned")
+	}
+	return s.world.Drop(s.playerLevel(playerID), playerID, player["playerDest"], slot, count)
+}
+
+func (s *Session) moveItem(playerID string, data map[string]interface{}) error {
+	from, errFrom := strconv.Atoi(getStringValue(data, "from"))
+	to, errTo := strconv.Atoi(getStringValue(data, "to"))
+	if errFrom != nil || errTo != nil {
+		return fmt.Errorf("invalid slot")
+	}
+	s.mutex.Lock()
+	s.world.Inventory(playerID).Move(from, to)
+	s.mutex.Unlock()
+	return nil
+}
+
+func itemError(playerID string, err error) map[string]string {
+	return map[string]string{
+		"type":      "item_error",
+		"error":     err.Error(),
+		"player_id": playerID,
+	}
+}
+
+func (s *Session) inventoryResponse(playerID string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return map[string]interface{}{
+		"type":      "inventory",
+		"slots":     append(sim.Inventory(nil), s.world.Inventory(playerID)...),
+		"player_id": playerID,
+	}
+}
+
+func (s *Session) groundItemsResponse(playerID string) map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.playerLevel(playerID)
+	return map[string]interface{}{
+		"type":      "ground_items",
+		"map":       l.Name,
+		"items":     l.GroundItemList(),
+		"player_id": playerID,
+	}
+}
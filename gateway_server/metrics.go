@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Commands that get their own label value, everything else is counted as
+// "other" so clients can't create arbitrary series.
+var knownCommands = map[string]bool{
+	"registerHost":     true,
+	"resumeHost":       true,
+	"hostPlayer":       true,
+	"registerPlayer":   true,
+	"player_data":      true,
+	"respawn":          true,
+	"get_players":      true,
+	"get_map":          true,
+	"player_positions": true,
+	"map_data":         true,
+	"get_chunks":       true,
+	"chunk_data":       true,
+	"world":            true,
+	"player_id":        true,
+	"chat":             true,
+	"chat_error":       true,
+	"farm":             true,
+	"farm_error":       true,
+	"get_crops":        true,
+	"crops":            true,
+	"inventory":        true,
+	"drop":             true,
+	"move_item":        true,
+	"item_error":       true,
+	"ground_items":     true,
+	"toggle_door":      true,
+	"doors":            true,
+	"door_error":       true,
+	"map_change":       true,
+	"player_left":      true,
+}
+
+var (
+	messagesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_messages_forwarded_total",
+		Help: "Messages forwarded between hosts and clients.",
+	}, []string{"command"})
+	bytesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_bytes_forwarded_total",
+		Help: "Bytes forwarded between hosts and clients.",
+	}, []string{"command"})
+	forwardErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_forward_errors_total",
+		Help: "Messages that could not be forwarded.",
+	}, []string{"command"})
+	sessionMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_session_messages_total",
+		Help: "Messages handled by gateway hosted sessions.",
+	}, []string{"command"})
+	sessionTickDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_session_tick_duration_seconds",
+		Help:    "Time a gateway hosted session spends on one message of a player.",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 2, 14),
+	})
+	forwardDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_forward_duration_seconds",
+		Help:    "Time to handle and forward one message.",
+		Buckets: prometheus.ExponentialBuckets(0.00005, 2, 14),
+	})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_active_lobbies",
+		Help: "Number of open lobbies.",
+	}, func() float64 {
+		lobbiesMutex.RLock()
+		defer lobbiesMutex.RUnlock()
+		return float64(len(lobbies))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_connected_clients",
+		Help: "Number of players connected to any lobby.",
+	}, func() float64 {
+		lobbiesMutex.RLock()
+		defer lobbiesMutex.RUnlock()
+		count := 0
+		for _, lobby := range lobbies {
+			lobby.clientsMutex.RLock()
+			count += len(lobby.Clients)
+			lobby.clientsMutex.RUnlock()
+		}
+		return float64(count)
+	})
+}
+
+func setupMetricsRoutes(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+// commandLabel returns the metric label for a message, hosts answer with a
+// "type" instead of a "command".
+func commandLabel(data map[string]interface{}) string {
+	command := getStringValue(data, "command")
+	if command == "" {
+		command = getStringValue(data, "type")
+	}
+	if !knownCommands[command] {
+		return "other"
+	}
+	return command
+}
+
+func recordForward(command string, size int, err error) {
+	if err != nil {
+		forwardErrors.WithLabelValues(command).Inc()
+		return
+	}
+	messagesForwarded.WithLabelValues(command).Inc()
+	bytesForwarded.WithLabelValues(command).Add(float64(size))
+}
+
+func observeForward(start time.Time) {
+	forwardDuration.Observe(time.Since(start).Seconds())
+}
+
+func observeSessionTick(start time.Time) {
+	sessionTickDuration.Observe(time.Since(start).Seconds())
+}
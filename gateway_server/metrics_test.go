@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want string
+	}{
+		{"command", map[string]interface{}{"command": "player_data"}, "player_data"},
+		{"host answer", map[string]interface{}{"type": "map_data"}, "map_data"},
+		{"command before type", map[string]interface{}{"command": "chat", "type": "map_data"}, "chat"},
+		{"unknown command", map[string]interface{}{"command": "made_up_by_client"}, "other"},
+		{"no command", map[string]interface{}{}, "other"},
+		{"not a string", map[string]interface{}{"command": 5.0}, "other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := commandLabel(test.data); got != test.want {
+				t.Fatalf("commandLabel(%v) = %q, want %q", test.data, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	base := startGateway(t)
+	_, lobbyID := registerHost(t, base, false)
+	joinLobby(t, base, lobbyID)
+
+	response, err := http.Get("http" + strings.TrimPrefix(base, "ws") + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	for _, want := range []string{
+		"gateway_active_lobbies 1",
+		"gateway_connected_clients 1",
+		"gateway_forward_duration_seconds_bucket",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+}
+
+func TestSessionTickMetrics(t *testing.T) {
+	base := startGateway(t)
+	lobbyID, err := createSessionLobby("test.map")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, playerID := joinLobby(t, base, lobbyID)
+	send(t, conn, map[string]interface{}{"command": "respawn", "respawn": "true", "name": "tester"})
+	receive(t, conn, hasField("player_id", playerID))
+
+	// The tick is observed after the answer is sent
+	want := []string{
+		`gateway_session_messages_total{command="respawn"}`,
+		"gateway_session_tick_duration_seconds_bucket",
+	}
+	var body string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		body = metricsBody(t, base)
+		if containsAll(body, want) && !strings.Contains(body, "gateway_session_tick_duration_seconds_count 0\n") {
+			return
+		}
+	}
+	t.Fatalf("session tick metrics missing, got:\n%s", body)
+}
+
+func metricsBody(t *testing.T, base string) string {
+	t.Helper()
+	response, err := http.Get("http" + strings.TrimPrefix(base, "ws") + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return string(body)
+}
+
+func containsAll(s string, parts []string) bool {
+	for _, part := range parts {
+		if !strings.Contains(s, part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Snapshot keeps the world the host sent and the last state it sent to its
+// clients, so it can be handed to a new host when the old one disconnects.
+// Maps and what is on them are kept by map name.
+type Snapshot struct {
+	mutex      sync.RWMutex
+	Start      interface{}
+	Maps       map[string]interface{}
+	Portals    map[string]interface{}
+	Players    map[string]interface{}
+	PlayerMaps map[string]interface{}
+	Names      map[string]interface{}
+
+	Crops       map[string]interface{}
+	Inventories map[string]interface{}
+	GroundItems map[string]interface{}
+	Doors       map[string]interface{}
+}
+
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Maps:       make(map[string]interface{}),
+		Portals:    make(map[string]interface{}),
+		Players:    make(map[string]interface{}),
+		PlayerMaps: make(map[string]interface{}),
+		Names:      make(map[string]interface{}),
+
+		Crops:       make(map[string]interface{}),
+		Inventories: make(map[string]interface{}),
+		GroundItems: make(map[string]interface{}),
+		Doors:       make(map[string]interface{}),
+	}
+}
+
+// update records map and player data from a message sent by the host.
+// Player lists sent to a client never include that client itself, so they
+// are merged instead of replaced.
+func (s *Snapshot) update(data map[string]interface{}) {
+	name := getStringValue(data, "map")
+	switch getStringValue(data, "type") {
+	case "player_positions":
+		if players, ok := data["players"].(map[string]interface{}); ok {
+			s.mutex.Lock()
+			for id, player := range players {
+				s.Players[id] = player
+				s.PlayerMaps[id] = name
+			}
+			s.PlayerMaps[getStringValue(data, "player_id")] = name
+			if names, ok := data["names"].(map[string]interface{}); ok {
+				for id, name := range names {
+					s.Names[id] = name
+				}
+			}
+			s.mutex.Unlock()
+		}
+	case "player_id":
+		// The host confirms a spawned player with its name
+		if name, ok := data["name"].(string); ok {
+			s.mutex.Lock()
+			s.Names[getStringValue(data, "player_id")] = name
+			s.mutex.Unlock()
+		}
+	case "map_change":
+		s.mutex.Lock()
+		s.PlayerMaps[getStringValue(data, "player_id")] = name
+		s.mutex.Unlock()
+	case "crops":
+		s.mutex.Lock()
+		s.Crops[name] = data["crops"]
+		s.mutex.Unlock()
+	case "inventory":
+		s.mutex.Lock()
+		s.Inventories[getStringValue(data, "player_id")] = data["slots"]
+		s.mutex.Unlock()
+	case "ground_items":
+		s.mutex.Lock()
+		s.GroundItems[name] = data["items"]
+		s.mutex.Unlock()
+	case "doors":
+		s.mutex.Lock()
+		s.Doors[name] = data["open"]
+		s.mutex.Unlock()
+	}
+}
+
+// setWorld records the maps of the world from the host's world message.
+// Clients only get chunks of the maps, so they can't be taken from the
+// messages to them.
+func (s *Snapshot) setWorld(data map[string]interface{}) {
+	maps, _ := data["maps"].(map[string]interface{})
+	portals, _ := data["portals"].(map[string]interface{})
+	s.mutex.Lock()
+	s.Start = data["start"]
+	s.Maps = make(map[string]interface{})
+	for name, m := range maps {
+		s.Maps[name] = m
+	}
+	s.Portals = make(map[string]interface{})
+	for name, p := range portals {
+		s.Portals[name] = p
+	}
+	s.mutex.Unlock()
+}
+
+func (s *Snapshot) removePlayer(playerID string) {
+	s.mutex.Lock()
+	delete(s.Players, playerID)
+	delete(s.PlayerMaps, playerID)
+	delete(s.Names, playerID)
+	delete(s.Inventories, playerID)
+	s.mutex.Unlock()
+}
+
+func (l *Lobby) getHost() *SafeConnection {
+	l.hostMutex.RLock()
+	defer l.hostMutex.RUnlock()
+	return l.Host
+}
+
+// hostDisconnected is called when a host connection closes. Without host
+// migration the lobby is closed, otherwise a remaining client is elected.
+func hostDisconnected(lobby_id string, conn *SafeConnection) {
+	lobbiesMutex.RLock()
+	lobby, exists := lobbies[lobby_id]
+	lobbiesMutex.RUnlock()
+	if !exists {
+		return
+	}
+
+	lobby.hostMutex.Lock()
+	if lobby.Host != conn {
+		// Another host already took over
+		lobby.hostMutex.Unlock()
+		return
+	}
+	lobby.Host = nil
+	lobby.hostMutex.Unlock()
+
+	if !lobby.Settings.HostMigration || !electHost(lobby_id, lobby) {
+		closeLobby(lobby_id)
+	}
+}
+
+// electHost picks the longest connected client as the new host and sends it
+// the world snapshot together with a fresh host token. The old host's own
+// player is skipped, it is leaving too. If the client does not resume the
+// lobby in time, the lobby gets closed.
+func electHost(lobby_id string, lobby *Lobby) bool {
+	lobby.hostMutex.RLock()
+	oldHostID := lobby.hostClientID
+	lobby.hostMutex.RUnlock()
+
+	lobby.clientsMutex.RLock()
+	var newHostID string
+	var newHostConn *SafeConnection
+	for _, id := range lobby.clientOrder {
+		if id == oldHostID {
+			continue
+		}
+		if conn, ok := lobby.Clients[id]; ok {
+			newHostID = id
+			newHostConn = conn
+			break
+		}
+	}
+	lobby.clientsMutex.RUnlock()
+	if newHostConn == nil {
+		return false
+	}
+
+	lobby.hostMutex.Lock()
+	lobby.HostToken = uuid.New().String()
+	token := lobby.HostToken
+	lobby.hostMutex.Unlock()
+	saveLobbies()
+
+	lobby.snapshot.mutex.RLock()
+	response_data := map[string]interface{}{
+		"type":         "host_migration",
+		"lobby_id":     lobby_id,
+		"host_token":   token,
+		"player_id":    newHostID,
+		"start":        lobby.snapshot.Start,
+		"maps":         lobby.snapshot.Maps,
+		"portals":      lobby.snapshot.Portals,
+		"players":      lobby.snapshot.Players,
+		"player_maps":  lobby.snapshot.PlayerMaps,
+		"names":        lobby.snapshot.Names,
+		"crops":        lobby.snapshot.Crops,
+		"inventories":  lobby.snapshot.Inventories,
+		"ground_items": lobby.snapshot.GroundItems,
+		"doors":        lobby.snapshot.Doors,
+	}
+	msg, err := json.Marshal(response_data)
+	lobby.snapshot.mutex.RUnlock()
+	if err != nil {
+		log.Printf("Error marshalling migration: %v", err)
+		return false
+	}
+
+	if err := newHostConn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Printf("Error sending migration to %s: %v", newHostID, err)
+		return false
+	}
+	fmt.Printf("Lobby %s: elected %s as new host\n", lobby_id, newHostID)
+
+	time.AfterFunc(config.MigrationTimeout.Duration, func() {
+		lobby.hostMutex.RLock()
+		abandoned := lobby.Host == nil && lobby.HostToken == token
+		lobby.hostMutex.RUnlock()
+		if abandoned {
+			fmt.Printf("Lobby %s: new host did not take over\n", lobby_id)
+			closeLobby(lobby_id)
+		}
+	})
+	return true
+}
+
+// resumeHost attaches conn as host of an existing lobby without a host.
+func resumeHost(lobby_id string, token string, conn *SafeConnection) bool {
+	lobbiesMutex.RLock()
+	lobby, exists := lobbies[lobby_id]
+	lobbiesMutex.RUnlock()
+	if !exists {
+		log.Printf("Lobby %s not found for resume", lobby_id)
+		return false
+	}
+
+	lobby.hostMutex.Lock()
+	defer lobby.hostMutex.Unlock()
+	if lobby.Host != nil || token == "" || token != lobby.HostToken {
+		log.Printf("Rejected host resume for lobby %s", lobby_id)
+		return false
+	}
+	lobby.Host = conn
+	lobby.HostPlayerID = ""
+	lobby.hostClientID = ""
+	fmt.Println("Lobby resumed:", lobby_id)
+	return true
+}
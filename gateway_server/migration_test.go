@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestElectHostSkipsOldHostPlayer(t *testing.T) {
+	base := startGateway(t)
+	host, lobbyID := registerHost(t, base, true)
+
+	// The host's game joins its own lobby first, like in gateway mode
+	_, hostPlayer := joinLobby(t, base, lobbyID)
+	send(t, host, map[string]interface{}{"command": "hostPlayer", "player_id": hostPlayer})
+	other, otherID := joinLobby(t, base, lobbyID)
+
+	host.Close()
+	migration := receive(t, other, hasField("type", "host_migration"))
+	if got := getStringValue(migration, "player_id"); got != otherID {
+		t.Fatalf("elected %s, want %s and not the old host's player %s", got, otherID, hostPlayer)
+	}
+	if getStringValue(migration, "host_token") == "" {
+		t.Fatal("migration has no host token")
+	}
+}
+
+func TestElectHostFirstJoined(t *testing.T) {
+	base := startGateway(t)
+	host, lobbyID := registerHost(t, base, true)
+	first, firstID := joinLobby(t, base, lobbyID)
+	joinLobby(t, base, lobbyID)
+
+	host.Close()
+	migration := receive(t, first, hasField("type", "host_migration"))
+	if got := getStringValue(migration, "player_id"); got != firstID {
+		t.Fatalf("elected %s, want the first joined player %s", got, firstID)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+// Session runs the game simulation of a lobby on the gateway, so the lobby
+// does not depend on one player's game being online.
+type Session struct {
+	mutex     sync.RWMutex
+	MapName   string // map or world file
+	players   map[string]map[string]sim.Rect
+	names     map[string]string
+	clocks    map[string]*sim.MoveClock
+	chatFlood sim.ChatFlood
+	started   time.Time
+
+	// Maps of the world with what is on them and the inventories, and the
+	// map each player is on
+	world      *sim.World
+	playerMaps map[string]string
+
+	// Chunks each player was sent of the map it is on
+	sentChunks sim.SentChunks
+
+	// Sends a message to all players of the lobby
+	broadcast func(label string, msg []byte)
+}
+
+// NewSession loads a map or a world from the map directory and starts an
+// empty world.
+func NewSession(mapName string) (*Session, error) {
+	levels, start, err := loadWorld(mapName)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		MapName:   mapName,
+		players:   make(map[string]map[string]sim.Rect),
+		names:     make(map[string]string),
+		clocks:    make(map[string]*sim.MoveClock),
+		chatFlood: make(sim.ChatFlood),
+		started:   time.Now(),
+
+		world:      sim.NewWorld(levels, start),
+		playerMaps: make(map[string]string),
+
+		sentChunks: make(sim.SentChunks),
+	}, nil
+}
+
+// handle answers a message of a player like the game's server would.
+func (s *Session) handle(playerID string, data map[string]interface{}, conn *SafeConnection) {
+	defer observeSessionTick(time.Now())
+	var response interface{}
+	switch getStringValue(data, "command") {
+	case "respawn":
+		respawn, _ := strconv.ParseBool(getStringValue(data, "respawn"))
+		if !respawn {
+			return
+		}
+		s.reply(playerID, conn, map[string]string{
+			"type":      "player_id",
+			"player_id": playerID,
+			"name":      s.spawn(playerID, getStringValue(data, "name")),
+		})
+		s.reply(playerID, conn, s.inventoryResponse(playerID))
+		s.sendMapState(playerID, conn)
+		return
+	case "player_data":
+		moved, picked, changed := s.move(playerID, data)
+		if moved == nil {
+			return
+		}
+		s.reply(playerID, conn, moved)
+		if changed {
+			s.mutex.RLock()
+			dest := s.players[playerID]["playerDest"]
+			s.mutex.RUnlock()
+			s.reply(playerID, conn, sim.MapChangeResponse(playerID, s.mapOf(playerID), dest))
+			s.sendMapState(playerID, conn)
+			return
+		}
+		if picked {
+			s.reply(playerID, conn, s.inventoryResponse(playerID))
+			s.sendAll("ground_items", s.groundItemsResponse(playerID))
+		}
+		return
+	case "chat":
+		chatError := s.chat(playerID, data)
+		if chatError == nil {
+			return
+		}
+		response = chatError
+	case "farm":
+		if err := s.farm(playerID, data); err != nil {
+			response = map[string]string{
+				"type":      "farm_error",
+				"error":     err.Error(),
+				"player_id": playerID,
+			}
+			break
+		}
+		s.reply(playerID, conn, s.inventoryResponse(playerID))
+		s.sendAll("crops", s.cropsResponse(playerID))
+		s.sendAll("ground_items", s.groundItemsResponse(playerID))
+		return
+	case "get_crops":
+		response = s.cropsResponse(playerID)
+	case "drop":
+		if err := s.drop(playerID, data); err != nil {
+			response = itemError(playerID, err)
+			break
+		}
+		s.reply(playerID, conn, s.inventoryResponse(playerID))
+		s.sendAll("ground_items", s.groundItemsResponse(playerID))
+		return
+	case "move_item":
+		if err := s.moveItem(playerID, data); err != nil {
+			response = itemError(playerID, err)
+			break
+		}
+		response = s.inventoryResponse(playerID)
+	case "toggle_door":
+		if err := s.toggleDoor(playerID, data); err != nil {
+			response = map[string]string{
+				"type":      "door_error",
+				"error":     err.Error(),
+				"player_id": playerID,
+			}
+			break
+		}
+		s.sendAll("doors", s.doorsResponse(playerID))
+		return
+	case "get_players":
+		players, names := s.playerList(playerID)
+		response = map[string]interface{}{
+			"type":      "player_positions",
+			"map":       s.mapOf(playerID),
+			"players":   players,
+			"names":     names,
+			"player_id": playerID,
+		}
+	case "get_map":
+		s.checkChunks(playerID, data)
+		response = s.mapDataResponse(playerID)
+	case "get_chunks":
+		for _, chunk := range s.chunks(playerID, data) {
+			s.reply(playerID, conn, chunk)
+		}
+		return
+	default:
+		log.Printf("Unknown session command: %s", getStringValue(data, "command"))
+		return
+	}
+	s.reply(playerID, conn, response)
+}
+
+// sendAll sends a response to every player of the lobby.
+func (s *Session) sendAll(label string, response map[string]interface{}) {
+	response["broadcast"] = true
+	msg, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling session response: %v", err)
+		return
+	}
+	if s.broadcast != nil {
+		s.broadcast(label, msg)
+	}
+}
+
+func (s *Session) reply(playerID string, conn *SafeConnection, response interface{}) {
+	msg, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshalling session response: %v", err)
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Printf("Error sending session response to %s: %v", playerID, err)
+	}
+}
+
+// spawn places a player at the start and returns the name it got.
+func (s *Session) spawn(playerID string, name string) string {
+	s.mutex.Lock()
+	s.players[playerID] = map[string]sim.Rect{
+		"playerDest": sim.SpawnDest,
+		"playerSrc":  sim.SpawnSrc,
+	}
+	name = sim.UniqueName(sim.SanitizeName(name), playerID, s.names)
+	s.names[playerID] = name
+	s.playerMaps[playerID] = s.world.Start
+	s.mutex.Unlock()
+	fmt.Printf("Session player %s (%s) spawned\n", playerID, name)
+	return name
+}
+
+func (s *Session) removePlayer(playerID string) {
+	s.mutex.Lock()
+	delete(s.players, playerID)
+	delete(s.clocks, playerID)
+	delete(s.names, playerID)
+	delete(s.chatFlood, playerID)
+	delete(s.world.Inventories, playerID)
+	delete(s.playerMaps, playerID)
+	delete(s.sentChunks, playerID)
+	s.mutex.Unlock()
+}
+
+// mapOf returns the name of the map a player is on.
+func (s *Session) mapOf(playerID string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.playerLevel(playerID).Name
+}
+
+// move applies a player's movement input, see handlePlayerMovement in the
+// game. It also reports whether the player picked up items and whether it
+// went through a portal to another map.
+func (s *Session) move(playerID string, data map[string]interface{}) (map[string]string, bool, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	player, exists := s.players[playerID]
+	if !exists {
+		log.Printf("Session player %s not found for movement", playerID)
+		return nil, false, false
+	}
+
+	if s.clocks[playerID] == nil {
+		s.clocks[playerID] = &sim.MoveClock{}
+	}
+	claimed, _ := strconv.ParseFloat(getStringValue(data, "dt"), 32)
+	moveX, moveY := sim.MessageMove(getStringValue(data, "move_x"), getStringValue(data, "move_y"), getStringValue(data, "actions"))
+	dt := s.clocks[playerID].Allow(float32(claimed))
+
+	// The game advances the walking animation every 8 frames at 60 FPS
+	frame := int(time.Since(s.started)/(8*time.Second/60)) % sim.WalkFrames
+	dest, src := s.playerLevel(playerID).Move(player["playerDest"], player["playerSrc"], moveX, moveY, dt, frame)
+	player["playerDest"] = dest
+	player["playerSrc"] = src
+
+	response := map[string]string{
+		"currentX":  fmt.Sprintf("%f", dest.X),
+		"currentY":  fmt.Sprintf("%f", dest.Y),
+		"player_id": playerID,
+	}
+	if s.takePortal(playerID) {
+		return response, false, true
+	}
+	return response, s.world.PickupItems(s.playerLevel(playerID), playerID, dest), false
+}
+
+func (s *Session) playerList(excludeID string) (map[string]map[string]sim.Rect, map[string]string) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	// Only players on the same map are sent
+	own := s.playerLevel(excludeID)
+	list := make(map[string]map[string]sim.Rect)
+	names := make(map[string]string)
+	for id, player := range s.players {
+		if id == excludeID || s.playerLevel(id) != own {
+			continue
+		}
+		list[id] = make(map[string]sim.Rect)
+		for key, rect := range player {
+			list[id][key] = rect
+		}
+		names[id] = s.names[id]
+	}
+	return list, names
+}
+
+// createSessionLobby opens a lobby that is hosted by the gateway itself.
+func createSessionLobby(mapName string) (string, error) {
+	session, err := NewSession(mapName)
+	if err != nil {
+		return "", err
+	}
+	lobbyID := uuid.New().String()
+	lobby := NewLobby(nil)
+	lobby.InviteCode = lobbyID
+	lobby.Settings.SessionMap = mapName
+	lobby.Session = session
+	session.broadcast = lobby.broadcast
+
+	lobbiesMutex.Lock()
+	lobbies[lobbyID] = lobby
+	lobbiesMutex.Unlock()
+	saveLobbies()
+
+	fmt.Printf("Session lobby created: %s (%s)\n", lobbyID, mapName)
+	return lobbyID, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LobbyRecord is what gets stored of a lobby, enough for host and players to
+// reclaim it after a gateway restart. Players are not stored, their resume
+// tokens are checked with PlayerKey.
+type LobbyRecord struct {
+	ID         string        `json:"id"`
+	InviteCode string        `json:"invite_code"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Settings   LobbySettings `json:"settings"`
+	HostToken  string        `json:"host_token"`
+	PlayerKey  string        `json:"player_key"`
+}
+
+type lobbyStore struct {
+	Lobbies []LobbyRecord `json:"lobbies"`
+}
+
+var storeMutex sync.Mutex
+
+func (l *Lobby) record(id string) LobbyRecord {
+	record := LobbyRecord{
+		ID:         id,
+		InviteCode: l.InviteCode,
+		CreatedAt:  l.CreatedAt,
+		Settings:   l.Settings,
+		PlayerKey:  l.playerKey,
+	}
+	l.hostMutex.RLock()
+	record.HostToken = l.HostToken
+	l.hostMutex.RUnlock()
+	return record
+}
+
+// saveLobbies writes all lobbies to the store file. It must not be called
+// while holding lobbiesMutex or a lobby mutex.
+func saveLobbies() {
+	if config.StoreFile == "" {
+		return
+	}
+
+	lobbiesMutex.RLock()
+	store := lobbyStore{Lobbies: make([]LobbyRecord, 0, len(lobbies))}
+	for id, lobby := range lobbies {
+		store.Lobbies = append(store.Lobbies, lobby.record(id))
+	}
+	lobbiesMutex.RUnlock()
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if err := writeStore(config.StoreFile, store); err != nil {
+		log.Printf("Error saving lobbies: %v", err)
+	}
+}
+
+// writeStore replaces the store file atomically, so a crash while writing
+// never leaves a half written file behind.
+func writeStore(path string, store lobbyStore) error {
+	data, err := json.MarshalIndent(store, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".lobbies-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// restoreLobbies loads the lobbies of the last run. They wait without host
+// until the host resumes them, lobbies not resumed within the grace period
+// are closed.
+func restoreLobbies() error {
+	if config.StoreFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(config.StoreFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var store lobbyStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("parsing %s: %w", config.StoreFile, err)
+	}
+
+	lobbiesMutex.Lock()
+	for _, record := range store.Lobbies {
+		lobby := NewLobby(nil)
+		lobby.InviteCode = record.InviteCode
+		lobby.CreatedAt = record.CreatedAt
+		lobby.Settings = record.Settings
+		lobby.HostToken = record.HostToken
+		if record.PlayerKey != "" {
+			// Stores of older gateways have no key, their players join again
+			lobby.playerKey = record.PlayerKey
+		}
+		if record.Settings.SessionMap != "" {
+			session, err := NewSession(record.Settings.SessionMap)
+			if err != nil {
+				log.Printf("Cannot restore session lobby %s: %v", record.ID, err)
+				continue
+			}
+			lobby.Session = session
+			session.broadcast = lobby.broadcast
+		}
+		lobbies[record.ID] = lobby
+		expireRestoredLobby(record.ID, lobby)
+	}
+	lobbiesMutex.Unlock()
+
+	log.Printf("Restored %d lobbies from %s", len(store.Lobbies), config.StoreFile)
+	return nil
+}
+
+func expireRestoredLobby(lobby_id string, lobby *Lobby) {
+	token := lobby.HostToken
+	time.AfterFunc(config.ResumeGrace.Duration, func() {
+		lobby.hostMutex.RLock()
+		abandoned := lobby.Session == nil && lobby.Host == nil && lobby.HostToken == token
+		lobby.hostMutex.RUnlock()
+		if abandoned {
+			fmt.Printf("Lobby %s: host did not come back\n", lobby_id)
+			closeLobby(lobby_id)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		record LobbyRecord
+	}{
+		{"empty lobby", LobbyRecord{
+			ID:        "empty",
+			CreatedAt: created,
+			HostToken: "host-token",
+			PlayerKey: "key-1",
+		}},
+		{"players and migration", LobbyRecord{
+			ID:        "players",
+			CreatedAt: created,
+			Settings:  LobbySettings{HostMigration: true},
+			HostToken: "host-token",
+			PlayerKey: "key-2",
+		}},
+		{"gateway hosted", LobbyRecord{
+			ID:        "session",
+			CreatedAt: created,
+			Settings:  LobbySettings{SessionMap: "test.map"},
+			HostToken: "host-token",
+			PlayerKey: "key-3",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetGateway(t)
+			config.StoreFile = filepath.Join(t.TempDir(), "lobbies.json")
+			config.ResumeGrace.Duration = time.Hour
+
+			lobby := NewLobby(nil)
+			lobby.InviteCode = test.record.ID
+			lobby.CreatedAt = test.record.CreatedAt
+			lobby.Settings = test.record.Settings
+			lobby.HostToken = test.record.HostToken
+			lobby.playerKey = test.record.PlayerKey
+			token := lobby.addClient("p1", nil)
+			lobby.removeClient("p1")
+			test.record.InviteCode = test.record.ID
+			lobbiesMutex.Lock()
+			lobbies[test.record.ID] = lobby
+			lobbiesMutex.Unlock()
+			saveLobbies()
+
+			lobbiesMutex.Lock()
+			lobbies = make(map[string]*Lobby)
+			lobbiesMutex.Unlock()
+			if err := restoreLobbies(); err != nil {
+				t.Fatal(err)
+			}
+
+			lobbiesMutex.RLock()
+			restored, exists := lobbies[test.record.ID]
+			lobbiesMutex.RUnlock()
+			if !exists {
+				t.Fatal("lobby was not restored")
+			}
+			if got := restored.record(test.record.ID); !reflect.DeepEqual(got, test.record) {
+				t.Fatalf("restored %+v, want %+v", got, test.record)
+			}
+			if !restored.reclaimPlayer("p1", token) {
+				t.Fatal("player cannot reclaim its ID with the token from before the restart")
+			}
+			if restored.reclaimPlayer("p2", token) {
+				t.Fatal("another player reclaimed an ID with the token of p1")
+			}
+			if restored.getHost() != nil {
+				t.Fatal("restored lobby has a host before it resumed")
+			}
+			if (restored.Session != nil) != (test.record.Settings.SessionMap != "") {
+				t.Fatalf("restored session %v for map %q", restored.Session, test.record.Settings.SessionMap)
+			}
+		})
+	}
+}
+
+func TestJoinAndLeaveDoNotSave(t *testing.T) {
+	resetGateway(t)
+	config.StoreFile = filepath.Join(t.TempDir(), "lobbies.json")
+	lobby := NewLobby(nil)
+	lobby.addClient("p1", nil)
+	lobby.removeClient("p1")
+	if _, err := os.Stat(config.StoreFile); !os.IsNotExist(err) {
+		t.Fatalf("store was written when a player joined and left: %v", err)
+	}
+}
+
+func TestRestoreLobbiesFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content *string
+		wantErr bool
+	}{
+		{"no store file", nil, false},
+		{"empty store", ptr(`{"lobbies": []}`), false},
+		{"broken store", ptr(`{"lobbies": [`), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetGateway(t)
+			config.StoreFile = filepath.Join(t.TempDir(), "lobbies.json")
+			if test.content != nil {
+				os.WriteFile(config.StoreFile, []byte(*test.content), 0o600)
+			}
+			if err := restoreLobbies(); (err != nil) != test.wantErr {
+				t.Fatalf("restoreLobbies() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string { return &s }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"main/autotile"
+	"main/sim"
+)
+
+// Autotile rules by tileset code, loaded from the tilesets file
+var autotileRules autotile.Rules
+
+// validMapName only allows plain file names, maps must come from the map
+// directory.
+func validMapName(name string) error {
+	if name == "" || name != filepath.Base(name) {
+		return fmt.Errorf("invalid map name %q", name)
+	}
+	return nil
+}
+
+func readMapFile(name string) ([]string, error) {
+	if err := validMapName(name); err != nil {
+		return nil, err
+	}
+	file, err := os.ReadFile(filepath.Join(config.MapDir, name))
+	if err != nil {
+		return nil, err
+	}
+	remNewLines := strings.Replace(string(file), "\n", " ", -1)
+	return autotileRules.ApplyFields(strings.Fields(remNewLines)), nil
+}
+
+// loadWorld loads a world file from the map directory, or a single map
+// file as a world with just that map. It returns the maps and the start.
+func loadWorld(name string) (map[string]*sim.Level, string, error) {
+	if err := validMapName(name); err != nil {
+		return nil, "", err
+	}
+	return sim.LoadWorld(config.MapDir, name, readMapFile)
+}
+
+// playerLevel returns the map a player is on. The caller must hold s.mutex.
+func (s *Session) playerLevel(playerID string) *sim.Level {
+	return s.world.Level(s.playerMaps[playerID])
+}
+
+// takePortal moves a player standing on a portal to the other map and
+// reports whether it did. The caller must hold s.mutex.
+func (s *Session) takePortal(playerID string) bool {
+	player := s.players[playerID]
+	name, dest, ok := s.world.TakePortal(s.playerLevel(playerID).Name, player["playerDest"])
+	if !ok {
+		return false
+	}
+	player["playerDest"] = dest
+	s.playerMaps[playerID] = name
+	return true
+}
+
+// mapDataResponse returns the size of the player's map, the tiles are sent
+// with get_chunks.
+func (s *Session) mapDataResponse(playerID string) map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.playerLevel(playerID).MapDataResponse(playerID)
+}
+
+// sendMapState sends a player the map it is on with everything on it.
+func (s *Session) sendMapState(playerID string, conn *SafeConnection) {
+	s.forgetChunks(playerID)
+	s.reply(playerID, conn, s.mapDataResponse(playerID))
+	s.reply(playerID, conn, s.cropsResponse(playerID))
+	s.reply(playerID, conn, s.groundItemsResponse(playerID))
+	s.reply(playerID, conn, s.doorsResponse(playerID))
+}
@@ -5,13 +5,18 @@ go 1.24.1
 require (
 	github.com/gen2brain/raylib-go/raylib v0.0.0-20250521210303-fca3bf26c568
 	github.com/gorilla/websocket v1.5.3
-	github.com/tawesoft/golib/v2 v2.16.0
+	github.com/prometheus/client_golang v1.22.0
 )
 
 require (
-	github.com/alessio/shellescape v1.4.2 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/ebitengine/purego v0.7.1 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.62.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
 	golang.org/x/exp v0.0.0-20240531132922-fd00a4e0eefc // indirect
-	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.15.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
 )
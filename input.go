@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/gameconfig"
+)
+
+// Action is something the player can do, bound to keys and gamepad buttons
+// in the settings.
+type Action = gameconfig.Action
+
+const (
+	ActionMoveUp      = gameconfig.ActionMoveUp
+	ActionMoveLeft    = gameconfig.ActionMoveLeft
+	ActionMoveDown    = gameconfig.ActionMoveDown
+	ActionMoveRight   = gameconfig.ActionMoveRight
+	ActionInteract    = gameconfig.ActionInteract
+	ActionToggleMusic = gameconfig.ActionToggleMusic
+	ActionChat        = gameconfig.ActionChat
+	ActionNextSlot    = gameconfig.ActionNextSlot
+	ActionPrevSlot    = gameconfig.ActionPrevSlot
+	ActionDrop        = gameconfig.ActionDrop
+	ActionInventory   = gameconfig.ActionInventory
+)
+
+// Only the first gamepad is used
+const gamepad int32 = 0
+
+// Names of the keys that can be bound, letters, digits and F keys are added
+// in init.
+var keyNames = map[string]int32{
+	"Space":        rl.KeySpace,
+	"Enter":        rl.KeyEnter,
+	"Tab":          rl.KeyTab,
+	"Backspace":    rl.KeyBackspace,
+	"Insert":       rl.KeyInsert,
+	"Delete":       rl.KeyDelete,
+	"Right":        rl.KeyRight,
+	"Left":         rl.KeyLeft,
+	"Down":         rl.KeyDown,
+	"Up":           rl.KeyUp,
+	"PageUp":       rl.KeyPageUp,
+	"PageDown":     rl.KeyPageDown,
+	"Home":         rl.KeyHome,
+	"End":          rl.KeyEnd,
+	"LeftShift":    rl.KeyLeftShift,
+	"LeftControl":  rl.KeyLeftControl,
+	"LeftAlt":      rl.KeyLeftAlt,
+	"RightShift":   rl.KeyRightShift,
+	"RightControl": rl.KeyRightControl,
+	"RightAlt":     rl.KeyRightAlt,
+	"Comma":        rl.KeyComma,
+	"Period":       rl.KeyPeriod,
+	"Minus":        rl.KeyMinus,
+	"Equal":        rl.KeyEqual,
+	"Semicolon":    rl.KeySemicolon,
+	"Slash":        rl.KeySlash,
+}
+
+// Gamepad buttons by their Xbox names
+var gamepadButtonNames = map[string]int32{
+	"DPadUp":     rl.GamepadButtonLeftFaceUp,
+	"DPadRight":  rl.GamepadButtonLeftFaceRight,
+	"DPadDown":   rl.GamepadButtonLeftFaceDown,
+	"DPadLeft":   rl.GamepadButtonLeftFaceLeft,
+	"Y":          rl.GamepadButtonRightFaceUp,
+	"B":          rl.GamepadButtonRightFaceRight,
+	"A":          rl.GamepadButtonRightFaceDown,
+	"X":          rl.GamepadButtonRightFaceLeft,
+	"LB":         rl.GamepadButtonLeftTrigger1,
+	"LT":         rl.GamepadButtonLeftTrigger2,
+	"RB":         rl.GamepadButtonRightTrigger1,
+	"RT":         rl.GamepadButtonRightTrigger2,
+	"Back":       rl.GamepadButtonMiddleLeft,
+	"Start":      rl.GamepadButtonMiddleRight,
+	"LeftStick":  rl.GamepadButtonLeftThumb,
+	"RightStick": rl.GamepadButtonRightThumb,
+}
+
+func init() {
+	for c := 'A'; c <= 'Z'; c++ {
+		keyNames[string(c)] = int32(c)
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyNames[string(c)] = int32(c)
+	}
+	for i := int32(1); i <= 12; i++ {
+		keyNames["F"+strconv.Itoa(int(i))] = rl.KeyF1 + i - 1
+	}
+}
+
+func keyName(key int32) string {
+	for name, code := range keyNames {
+		if code == key {
+			return name
+		}
+	}
+	return ""
+}
+
+// pressedGamepadButton returns the name of a gamepad button pressed this
+// frame, or "".
+func pressedGamepadButton() string {
+	if !rl.IsGamepadAvailable(gamepad) {
+		return ""
+	}
+	for name, button := range gamepadButtonNames {
+		if rl.IsGamepadButtonPressed(gamepad, button) {
+			return name
+		}
+	}
+	return ""
+}
+
+// actionDown reports whether a key or button bound to action is held.
+func actionDown(action Action) bool {
+	for _, name := range settings.Keys[action] {
+		if key, ok := keyNames[name]; ok && rl.IsKeyDown(key) {
+			return true
+		}
+	}
+	if rl.IsGamepadAvailable(gamepad) {
+		for _, name := range settings.Gamepad[action] {
+			if button, ok := gamepadButtonNames[name]; ok && rl.IsGamepadButtonDown(gamepad, button) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// actionPressed reports whether a key or button bound to action was pressed
+// this frame.
+func actionPressed(action Action) bool {
+	for _, name := range settings.Keys[action] {
+		if key, ok := keyNames[name]; ok && rl.IsKeyPressed(key) {
+			return true
+		}
+	}
+	if rl.IsGamepadAvailable(gamepad) {
+		for _, name := range settings.Gamepad[action] {
+			if button, ok := gamepadButtonNames[name]; ok && rl.IsGamepadButtonPressed(gamepad, button) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func bindingText(action Action) string {
+	text := strings.Join(settings.Keys[action], ", ")
+	if buttons := settings.Gamepad[action]; len(buttons) > 0 {
+		text += " / " + strings.Join(buttons, ", ")
+	}
+	return text
+}
+
+// moveInput returns the direction the player wants to move in, each axis
+// from -1 to 1. The left stick is used when it is pushed further than the
+// deadzone, otherwise the move actions.
+func moveInput() (float32, float32) {
+	if rl.IsGamepadAvailable(gamepad) {
+		x := rl.GetGamepadAxisMovement(gamepad, rl.GamepadAxisLeftX)
+		y := rl.GetGamepadAxisMovement(gamepad, rl.GamepadAxisLeftY)
+		length := float32(math.Hypot(float64(x), float64(y)))
+		if length > settings.Deadzone {
+			// Start from 0 at the edge of the deadzone
+			scale := clamp((length-settings.Deadzone)/(1-settings.Deadzone), 0, 1) / length
+			return x * scale, y * scale
+		}
+	}
+	return actionMove(actionDown)
+}
+
+// actionMove turns the held move actions into a direction.
+func actionMove(held func(Action) bool) (float32, float32) {
+	var x, y float32
+	if held(ActionMoveUp) {
+		y--
+	}
+	if held(ActionMoveLeft) {
+		x--
+	}
+	if held(ActionMoveDown) {
+		y++
+	}
+	if held(ActionMoveRight) {
+		x++
+	}
+	return x, y
+}
+
+// heldActions lists the bound actions that are held, for the input message.
+func heldActions() []Action {
+	var held []Action
+	for action := range settings.Keys {
+		if actionDown(action) {
+			held = append(held, action)
+		}
+	}
+	return held
+}
+
+func encodeActions(actions []Action) string {
+	names := make([]string, len(actions))
+	for i, action := range actions {
+		names[i] = string(action)
+	}
+	return strings.Join(names, ",")
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+
+	"main/sim"
+)
+
+const (
+	itemsFile = "resource/items.json"
+
+	// The first hotbarSize inventory slots are the hotbar
+	hotbarSize = 9
+
+	// Hotbar slot size and gap on screen
+	slotSize = 40
+	slotGap  = 4
+)
+
+var (
+	// What this client got from the server, guarded by inventoryMutex
+	inventoryMutex sync.RWMutex
+	inventory      = make(sim.Inventory, sim.InventorySize)
+	groundView     []sim.GroundItem
+
+	selectedSlot  int
+	inventoryOpen bool
+	heldSlot      = -1
+)
+
+func forgetInventory(playerID string) {
+	worldMutex.Lock()
+	delete(world.Inventories, playerID)
+	worldMutex.Unlock()
+}
+
+// pickupItems moves items near a player's feet into its inventory and
+// reports whether anything was picked up.
+func pickupItems(playerID string, name string, dest rl.Rectangle) bool {
+	worldMutex.Lock()
+	defer worldMutex.Unlock()
+	l := world.Level(name)
+	if l == nil {
+		return false
+	}
+	return world.PickupItems(l, playerID, sim.Rect(dest))
+}
+
+func inventoryMessage(playerID string) []byte {
+	worldMutex.Lock()
+	msg, err := json.Marshal(map[string]interface{}{
+		"type":      "inventory",
+		"slots":     world.Inventory(playerID),
+		"player_id": playerID,
+	})
+	worldMutex.Unlock()
+	if err != nil {
+		log.Println("Error marshalling inventory:", err)
+	}
+	return msg
+}
+
+// groundItemsMessage returns the items on the ground of a map for a
+// player, or for everyone if broadcast is set.
+func groundItemsMessage(playerID string, name string, broadcast bool) []byte {
+	worldMutex.Lock()
+	list := []sim.GroundItem{}
+	if l := world.Level(name); l != nil {
+		name = l.Name
+		list = l.GroundItemList()
+	}
+	response := map[string]interface{}{
+		"type":      "ground_items",
+		"map":       name,
+		"items":     list,
+		"player_id": playerID,
+	}
+	if broadcast {
+		response["broadcast"] = true
+	}
+	msg, err := json.Marshal(response)
+	worldMutex.Unlock()
+	if err != nil {
+		log.Println("Error marshalling ground items:", err)
+	}
+	return msg
+}
+
+func sendItemError(conn MessageWriter, playerID string, err error) {
+	response, _ := json.Marshal(map[string]string{
+		"type":      "item_error",
+		"error":     err.Error(),
+		"player_id": playerID,
+	})
+	conn.WriteMessage(websocket.TextMessage, response)
+}
+
+// handleDropWS drops items of a slot at the player's feet.
+func handleDropWS(data map[string]string, conn MessageWriter, broadcast func([]byte)) {
+	playerID := data["player_id"]
+	slot, _ := strconv.Atoi(data["slot"])
+	count, err := strconv.Atoi(data["count"])
+	if err != nil {
+		count = 1
+	}
+
+	playersMutex.RLock()
+	player, exists := joinedPlayers[playerID]
+	var dest rl.Rectangle
+	if exists {
+		dest = player["playerDest"]
+	}
+	name := playerMap(playerID)
+	playersMutex.RUnlock()
+	if !exists {
+		sendItemError(conn, playerID, fmt.Errorf("not spawned"))
+		return
+	}
+
+	worldMutex.Lock()
+	err = fmt.Errorf("nothing to drop")
+	if l := world.Level(name); l != nil {
+		err = world.Drop(l, playerID, sim.Rect(dest), slot, count)
+	}
+	worldMutex.Unlock()
+	if err != nil {
+		sendItemError(conn, playerID, err)
+		return
+	}
+	conn.WriteMessage(websocket.TextMessage, inventoryMessage(playerID))
+	broadcast(groundItemsMessage(playerID, name, true))
+}
+
+func handleMoveItemWS(data map[string]string, conn MessageWriter) {
+	playerID := data["player_id"]
+	from, errFrom := strconv.Atoi(data["from"])
+	to, errTo := strconv.Atoi(data["to"])
+	if errFrom != nil || errTo != nil {
+		sendItemError(conn, playerID, fmt.Errorf("invalid slot"))
+		return
+	}
+	worldMutex.Lock()
+	world.Inventory(playerID).Move(from, to)
+	worldMutex.Unlock()
+	conn.WriteMessage(websocket.TextMessage, inventoryMessage(playerID))
+}
+
+func handleInventoryResponse(message []byte) {
+	var response struct {
+		Slots sim.Inventory `json:"slots"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		log.Println("Error parsing inventory:", err)
+		return
+	}
+	inventoryMutex.Lock()
+	inventory = response.Slots
+	inventoryMutex.Unlock()
+}
+
+func handleGroundItemsResponse(message []byte) {
+	var response struct {
+		Map   string           `json:"map"`
+		Items []sim.GroundItem `json:"items"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		log.Println("Error parsing ground items:", err)
+		return
+	}
+	if !onClientMap(response.Map) {
+		return
+	}
+	inventoryMutex.Lock()
+	groundView = response.Items
+	inventoryMutex.Unlock()
+}
+
+func sendItemCommandWS(data map[string]string) {
+	if websocket_client == nil {
+		return
+	}
+	data["player_id"] = joinPlayerID
+	msg, _ := json.Marshal(data)
+	if err := websocket_client.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Printf("Error sending %s request: %v", data["command"], err)
+	}
+}
+
+// slotRect returns where an inventory slot is drawn. The hotbar is at the
+// bottom, the rest of the inventory above the middle of the screen.
+func slotRect(slot int) rl.Rectangle {
+	width := float32(hotbarSize*slotSize + (hotbarSize-1)*slotGap)
+	x := (float32(screenWidth)-width)/2 + float32(slot%hotbarSize)*(slotSize+slotGap)
+	y := float32(screenHeight) - slotSize - 10
+	if slot >= hotbarSize {
+		row := slot/hotbarSize - 1
+		y = float32(screenHeight)/2 - slotSize + float32(row)*(slotSize+slotGap)
+	}
+	return rl.NewRectangle(x, y, slotSize, slotSize)
+}
+
+// updateInventory handles selecting hotbar slots, dropping items and moving
+// them around with the mouse while the inventory is open.
+func updateInventory() {
+	for i := 0; i < hotbarSize; i++ {
+		if rl.IsKeyPressed(rl.KeyOne + int32(i)) {
+			selectedSlot = i
+		}
+	}
+	scroll := 0
+	if wheel := rl.GetMouseWheelMove(); wheel != 0 {
+		scroll = -int(math.Copysign(1, float64(wheel)))
+	}
+	if actionPressed(ActionNextSlot) {
+		scroll++
+	}
+	if actionPressed(ActionPrevSlot) {
+		scroll--
+	}
+	selectedSlot = ((selectedSlot+scroll)%hotbarSize + hotbarSize) % hotbarSize
+
+	if actionPressed(ActionDrop) {
+		sendItemCommandWS(map[string]string{
+			"command": "drop",
+			"slot":    strconv.Itoa(selectedSlot),
+			"count":   "1",
+		})
+	}
+	if actionPressed(ActionInventory) {
+		inventoryOpen = !inventoryOpen
+		heldSlot = -1
+	}
+
+	if !rl.IsMouseButtonPressed(rl.MouseButtonLeft) {
+		return
+	}
+	slots := hotbarSize
+	if inventoryOpen {
+		slots = sim.InventorySize
+	}
+	for slot := 0; slot < slots; slot++ {
+		if !rl.CheckCollisionPointRec(rl.GetMousePosition(), slotRect(slot)) {
+			continue
+		}
+		switch {
+		case !inventoryOpen:
+			selectedSlot = slot
+		case heldSlot == -1:
+			inventoryMutex.RLock()
+			if slot < len(inventory) && inventory[slot].Item != "" {
+				heldSlot = slot
+			}
+			inventoryMutex.RUnlock()
+		default:
+			sendItemCommandWS(map[string]string{
+				"command": "move_item",
+				"from":    strconv.Itoa(heldSlot),
+				"to":      strconv.Itoa(slot),
+			})
+			heldSlot = -1
+		}
+	}
+}
+
+// drawItemIcon draws an item with simple shapes in a square of size around
+// center.
+func drawItemIcon(id string, center rl.Vector2, size float32) {
+	item := sim.ItemTypes[id]
+	color := rl.NewColor(item.Color[0], item.Color[1], item.Color[2], 255)
+	green := rl.NewColor(76, 153, 0, 255)
+	switch item.Icon {
+	case "seeds":
+		for _, offset := range [][2]float32{{-0.2, 0.1}, {0.15, 0.15}, {0, -0.15}} {
+			rl.DrawCircleV(rl.NewVector2(center.X+offset[0]*size, center.Y+offset[1]*size), size*0.12, color)
+		}
+	case "bundle":
+		for _, dx := range []float32{-0.15, 0, 0.15} {
+			top := rl.NewVector2(center.X+dx*size, center.Y-size*0.3)
+			rl.DrawLineEx(rl.NewVector2(center.X, center.Y+size*0.35), top, size*0.06, green)
+			rl.DrawCircleV(top, size*0.12, color)
+		}
+	case "root":
+		rl.DrawTriangle(
+			rl.NewVector2(center.X-size*0.15, center.Y-size*0.15),
+			rl.NewVector2(center.X, center.Y+size*0.4),
+			rl.NewVector2(center.X+size*0.15, center.Y-size*0.15),
+			color)
+		rl.DrawLineEx(rl.NewVector2(center.X, center.Y-size*0.15), rl.NewVector2(center.X-size*0.1, center.Y-size*0.4), size*0.06, green)
+		rl.DrawLineEx(rl.NewVector2(center.X, center.Y-size*0.15), rl.NewVector2(center.X+size*0.1, center.Y-size*0.4), size*0.06, green)
+	default:
+		rl.DrawCircleV(center, size*0.3, color)
+	}
+}
+
+// drawGroundItems draws the items lying on the map.
+func drawGroundItems() {
+	inventoryMutex.RLock()
+	defer inventoryMutex.RUnlock()
+	for _, item := range groundView {
+		rl.DrawEllipse(int32(item.X), int32(item.Y)+3, 5, 2, rl.Fade(rl.Black, 0.3))
+		drawItemIcon(item.Item, rl.NewVector2(item.X, item.Y-2), 10)
+	}
+}
+
+// drawHotbar draws the hotbar and, while it is open, the rest of the
+// inventory.
+func drawHotbar() {
+	slots := hotbarSize
+	if inventoryOpen {
+		slots = sim.InventorySize
+		top := slotRect(hotbarSize)
+		rl.DrawText("Inventory", int32(top.X), int32(top.Y)-26, 20, rl.White)
+	}
+	inventoryMutex.RLock()
+	defer inventoryMutex.RUnlock()
+	for slot := 0; slot < slots && slot < len(inventory); slot++ {
+		rect := slotRect(slot)
+		rl.DrawRectangleRec(rect, rl.Fade(rl.Black, 0.5))
+		border := rl.Fade(rl.White, 0.4)
+		switch slot {
+		case heldSlot:
+			border = rl.SkyBlue
+		case selectedSlot:
+			border = rl.Yellow
+		}
+		rl.DrawRectangleLinesEx(rect, 2, border)
+
+		stack := inventory[slot]
+		if stack.Item == "" {
+			continue
+		}
+		drawItemIcon(stack.Item, rl.NewVector2(rect.X+rect.Width/2, rect.Y+rect.Height/2), slotSize*0.8)
+		if stack.Count > 1 {
+			count := strconv.Itoa(stack.Count)
+			rl.DrawText(count, int32(rect.X+rect.Width)-rl.MeasureText(count, 10)-3, int32(rect.Y+rect.Height)-12, 10, rl.White)
+		}
+	}
+
+	if selectedSlot < len(inventory) && inventory[selectedSlot].Item != "" {
+		name := sim.ItemName(inventory[selectedSlot].Item)
+		rect := slotRect(0)
+		x := (screenWidth - rl.MeasureText(name, 20)) / 2
+		rl.DrawText(name, x+1, int32(rect.Y)-23, 20, rl.Fade(rl.Black, 0.6))
+		rl.DrawText(name, x, int32(rect.Y)-24, 20, rl.White)
+	}
+}
@@ -2,27 +2,31 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	rl "github.com/gen2brain/raylib-go/raylib"
 	"github.com/gorilla/websocket"
-	"github.com/tawesoft/golib/v2/dialog"
-)
 
-const (
-	screenWidth  = 1000
-	screenHeight = 480
+	"main/ratelimit"
+	"main/sim"
 )
 
 var (
+	// Window size, set from the settings
+	screenWidth  int32 = 1000
+	screenHeight int32 = 480
+
 	running  = true
 	bkgColor = rl.NewColor(147, 211, 196, 255)
 
@@ -35,19 +39,18 @@ var (
 	woodHouseRoofSprite  rl.Texture2D
 	tilledSprite         rl.Texture2D
 	doorSprite           rl.Texture2D
-	tex                  rl.Texture2D
 	playerSprite         rl.Texture2D
 
 	// Player state
-	playerSrc                                     rl.Rectangle
-	playerDest                                    rl.Rectangle
-	playerMoving                                  bool
-	playerDir                                     int
-	playerUp, playerDown, playerRight, playerLeft bool
-	playerFrame                                   int
-	maxFrames                                     = 4
-	frameCount                                    int
-	playerSpeed                                   float32 = 3
+	playerSrc                rl.Rectangle
+	playerDest               rl.Rectangle
+	playerMoving             bool
+	playerDir                int
+	playerMoveX, playerMoveY float32
+	playerActions            []Action
+	playerFrame              int
+	maxFrames                = sim.WalkFrames
+	frameTimer               float32
 
 	// Map
 	tileDest   rl.Rectangle
@@ -55,8 +58,11 @@ var (
 	tileMap    []int
 	srcMap     []string
 	mapW, mapH int
-	map_file   = "resource/maps/second.map"
-	loadedMap  []string
+	map_file   = "resource/maps/world.json"
+
+	// Set when this client became host through migration, the world then
+	// comes from the gateway snapshot instead of map_file
+	mapFromSnapshot bool
 
 	// Audio
 	musicPaused bool
@@ -70,29 +76,39 @@ var (
 	server_url_ws       string
 	gateway_server      string
 	gateway_invite_code string
+	gateway_host_token  string
+	lobbyCreated        = make(chan struct{}, 1)
+	playerRegistered    = make(chan error, 1)
 	websocket_client    *websocket.Conn
-	websocket_gateway   *websocket.Conn
+	websocket_gateway   atomic.Pointer[SafeConnection] // replaced on reconnects, read with gatewayConn
+	hostServer          *http.Server
 
 	// Multiplayer
-	playersMutex      sync.RWMutex
-	joinedPlayers     = make(map[string]map[string]rl.Rectangle)
-	joinPlayerID_old  int
-	joinPlayerID      string
-	lastPlayerUpdate  time.Time
-	lastMapUpdate     time.Time
-	mapUpdateCooldown = 1000
+	playersMutex         sync.RWMutex
+	joinedPlayers        = make(map[string]map[string]rl.Rectangle)
+	moveClocks           = make(map[string]*sim.MoveClock)
+	joinPlayerID_old     int
+	joinPlayerID         string
+	lastPlayerUpdate     time.Time
+	playerUpdateInterval = 50 * time.Millisecond
+	lastMapUpdate        time.Time
+	mapUpdateCooldown    = 1000
 )
 
 type MovementData struct {
-	PlayerID    string `json:"playerid"`
-	PlayerUp    bool   `json:"playerUp"`
-	PlayerLeft  bool   `json:"playerLeft"`
-	PlayerDown  bool   `json:"playerDown"`
-	PlayerRight bool   `json:"playerRight"`
+	PlayerID string   `json:"playerid"`
+	Actions  []Action `json:"actions"`
+	// Analog stick input, sent instead of the move actions when Analog is set
+	MoveX  float32 `json:"move_x"`
+	MoveY  float32 `json:"move_y"`
+	Analog bool    `json:"-"`
+	// Seconds the input was held, the server limits it
+	DT float32 `json:"dt"`
 }
 
 type RespawnData struct {
-	Respawn bool `json:"respawn"`
+	Respawn bool   `json:"respawn"`
+	Name    string `json:"name"`
 }
 
 type GetPlayersData struct {
@@ -102,93 +118,65 @@ type GetPlayersData struct {
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	CheckOrigin:     checkOrigin,
 }
 
 func drawScene() {
-	// Draw map tiles
-	for i := 0; i < len(tileMap); i++ {
-		if tileMap[i] != 0 {
-			tileDest.X = tileDest.Width * float32(i%mapW)
-			tileDest.Y = tileDest.Height * float32(i/mapW)
-
-			// Select texture based on source map
-			switch srcMap[i] {
-			case "g":
-				tex = grassSprite
-			case "f":
-				tex = fenceSprite
-			case "h":
-				tex = hillSprite
-			case "w":
-				tex = waterSprite
-			case "ww":
-				tex = woodHouseWallsSprite
-			case "wr":
-				tex = woodHouseRoofSprite
-			case "t":
-				tex = tilledSprite
-			case "d":
-				tex = doorSprite
-			}
+	drawMapTiles()
+	drawCrops()
+	drawGroundItems()
 
-			// Draw grass background for certain tiles
-			if srcMap[i] == "ww" || srcMap[i] == "f" || srcMap[i] == "d" || srcMap[i] == "wr" {
-				tileSrc.X = 0
-				tileSrc.Y = tileSrc.Height * 5
-				rl.DrawTexturePro(grassSprite, tileSrc, tileDest, rl.NewVector2(tileDest.Width, tileDest.Height), 0, rl.White)
-			}
-
-			tileSrc.X = tileSrc.Width * float32((tileMap[i]-1)%int(tex.Width/int32(tileSrc.Width)))
-			tileSrc.Y = tileSrc.Height * float32((tileMap[i]-1)/int(tex.Width/int32(tileSrc.Width)))
-
-			rl.DrawTexturePro(tex, tileSrc, tileDest, rl.NewVector2(tileDest.Width, tileDest.Height), 0, rl.White)
+	// Draw players and tall tiles by where they stand, the host server
+	// knows players of all maps
+	sprites := tallSprites()
+	playersMutex.RLock()
+	ownMap := playerMap(joinPlayerID)
+	for playerID, val := range joinedPlayers {
+		if playerID != joinPlayerID && playerMap(playerID) == ownMap {
+			sprites = append(sprites, playerDepthSprite(val["playerSrc"], val["playerDest"]))
 		}
 	}
+	playersMutex.RUnlock()
+
+	// The local player goes last, in front of others standing as far down
+	sprites = append(sprites, playerDepthSprite(playerSrc, playerDest))
+	drawDepthSorted(sprites)
 
-	// Draw other players
+	// Name tags go above all players
 	playersMutex.RLock()
 	for playerID, val := range joinedPlayers {
-		if playerID != joinPlayerID {
-			rl.DrawTexturePro(playerSprite, val["playerSrc"], val["playerDest"], rl.NewVector2(val["playerDest"].Width, val["playerDest"].Height), 0, rl.White)
+		if playerID != joinPlayerID && playerMap(playerID) == ownMap {
+			drawNameTag(playerNames[playerID], val["playerDest"])
 		}
 	}
 	playersMutex.RUnlock()
-
-	// Draw local player
-	rl.DrawTexturePro(playerSprite, playerSrc, playerDest, rl.NewVector2(playerDest.Width, playerDest.Height), 0, rl.White)
+	drawNameTag(ownName, playerDest)
 }
 
 func input() {
-	if rl.IsKeyDown(rl.KeyW) || rl.IsKeyDown(rl.KeyUp) {
-		playerMoving = true
-		playerDir = 1
-		playerUp = true
-	}
-	if rl.IsKeyDown(rl.KeyA) || rl.IsKeyDown(rl.KeyLeft) {
-		playerMoving = true
-		playerDir = 2
-		playerLeft = true
+	if settingsOpen || chatOpen {
+		return
 	}
-	if rl.IsKeyDown(rl.KeyS) || rl.IsKeyDown(rl.KeyDown) {
+	playerActions = heldActions()
+	playerMoveX, playerMoveY = moveInput()
+	if playerMoveX != 0 || playerMoveY != 0 {
 		playerMoving = true
-		playerDir = 0
-		playerDown = true
+		playerDir = sim.MoveDirection(playerMoveX, playerMoveY, playerDir)
 	}
-	if rl.IsKeyDown(rl.KeyD) || rl.IsKeyDown(rl.KeyRight) {
-		playerMoving = true
-		playerDir = 3
-		playerRight = true
+	updateInventory()
+	if actionPressed(ActionInteract) {
+		interact()
 	}
-	if rl.IsKeyPressed(rl.KeyQ) {
+	if actionPressed(ActionToggleMusic) {
 		musicPaused = !musicPaused
+		settings.Music = !musicPaused
+		saveSettings()
 	}
 }
 
 func update() {
 	running = !rl.WindowShouldClose()
+	dt := clamp(rl.GetFrameTime(), 0, sim.MaxMoveStep)
 
 	if time.Since(lastMapUpdate) > time.Duration(mapUpdateCooldown)*time.Millisecond {
 		loadMap()
@@ -203,18 +191,10 @@ func update() {
 
 	if playerMoving {
 		// Apply movement to local player
-		if playerUp {
-			playerDest.Y -= playerSpeed
-		}
-		if playerLeft {
-			playerDest.X -= playerSpeed
-		}
-		if playerDown {
-			playerDest.Y += playerSpeed
-		}
-		if playerRight {
-			playerDest.X += playerSpeed
-		}
+		dx, dy := sim.MoveStep(playerMoveX, playerMoveY, dt)
+		doorsMutex.RLock()
+		playerDest = rl.Rectangle(currentMap().MoveBlocked(sim.Rect(playerDest), dx, dy, openDoors))
+		doorsMutex.RUnlock()
 
 		// Update local player in server's map if host
 		if host_type == "host" || host_type == "gateway" {
@@ -223,37 +203,33 @@ func update() {
 
 		// Send movement data via WebSocket
 		if host_type == "join" || host_type == "host" || host_type == "gateway" || host_type == "gatewayjoin" {
+			moveX, moveY := actionMove(actionDown)
 			data := MovementData{
-				PlayerID:    joinPlayerID,
-				PlayerUp:    playerUp,
-				PlayerLeft:  playerLeft,
-				PlayerDown:  playerDown,
-				PlayerRight: playerRight,
+				PlayerID: joinPlayerID,
+				Actions:  playerActions,
+				MoveX:    playerMoveX,
+				MoveY:    playerMoveY,
+				Analog:   moveX != playerMoveX || moveY != playerMoveY,
+				DT:       dt,
 			}
 			sendDataMovementWS(data)
 		}
-
-		if frameCount%8 == 1 {
-			playerFrame++
-		}
-	} else if frameCount%45 == 1 {
+	}
+	frameTimer += dt
+	if (playerMoving && frameTimer >= walkFrameTime) || frameTimer >= idleFrameTime {
+		frameTimer = 0
 		playerFrame++
 	}
 
 	// Request player positions periodically via WebSocket
-	//if time.Since(lastPlayerUpdate) > 100*time.Millisecond {
-	requestPlayerPositionsWS()
-	//	lastPlayerUpdate = time.Now()
-	//}
-	if host_type == "join" || host_type == "gatewayjoin" {
-		if time.Since(lastMapUpdate) > time.Duration(mapUpdateCooldown)*time.Millisecond {
-			requestMapDataWS()
-			time.Sleep(1 * time.Second)
-			loadMap()
-			lastMapUpdate = time.Now()
-		}
+	if time.Since(lastPlayerUpdate) > playerUpdateInterval {
+		requestPlayerPositionsWS()
+		lastPlayerUpdate = time.Now()
+	}
+	if time.Since(lastCropUpdate) > cropUpdateInterval {
+		requestCropsWS()
+		lastCropUpdate = time.Now()
 	}
-	frameCount++
 	playerSrc.Y = playerSrc.Height
 	if !playerMoving && playerFrame > 1 {
 		playerFrame = 0
@@ -268,10 +244,12 @@ func update() {
 
 	// Update camera
 	cam.Target = rl.NewVector2(float32(playerDest.X-(playerDest.Width/2)), float32(playerDest.Y-(playerDest.Height/2)))
+	updateChunks()
 
 	// Reset movement flags
 	playerMoving = false
-	playerUp, playerDown, playerRight, playerLeft = false, false, false, false
+	playerMoveX, playerMoveY = 0, 0
+	playerActions = nil
 }
 
 func updateLocalPlayerOnServer() {
@@ -284,6 +262,7 @@ func updateLocalPlayerOnServer() {
 }
 
 func render() {
+	cacheChunks()
 	rl.BeginDrawing()
 	rl.ClearBackground(bkgColor)
 	rl.BeginMode2D(cam)
@@ -291,68 +270,41 @@ func render() {
 	drawScene()
 
 	rl.EndMode2D()
+	drawHotbar()
+	drawChat()
+	if settingsOpen {
+		drawSettingsScreen()
+	}
 	rl.EndDrawing()
 }
 
+// loadMap asks the server which map this client is on, the tiles come in
+// chunks after that. The host reads its map file again first so edits show
+// up while hosting.
 func loadMap() {
-	tileMap = tileMap[:0]
-	srcMap = srcMap[:0]
-
-	if host_type == "host" || host_type == "gateway" {
-		file, err := os.ReadFile(map_file)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		remNewLines := strings.Replace(string(file), "\n", " ", -1)
-		loadedMap = strings.Fields(remNewLines)
-	} else if host_type == "join" || host_type == "gatewayjoin" {
-		requestMapDataWS()
-		//fmt.Println(loadedMap)
-	}
-	sliced := loadedMap
-
-	mapW = -1
-	mapH = -1
-	for i := 0; i < len(sliced); i++ {
-		if mapW == -1 {
-			s, err := strconv.ParseInt(sliced[i], 10, 64)
-			if err != nil {
-				continue
-			}
-			mapW = int(s)
-		} else if mapH == -1 {
-			s, err := strconv.ParseInt(sliced[i], 10, 64)
-			if err != nil {
-				continue
-			}
-			mapH = int(s)
-		} else if len(tileMap) < mapW*mapH {
-			s, err := strconv.ParseInt(sliced[i], 10, 64)
-			if err != nil {
-				continue
-			}
-			m := int(s)
-			tileMap = append(tileMap, m)
-		} else {
-			srcMap = append(srcMap, sliced[i])
-		}
+	if (host_type == "host" || host_type == "gateway") && !mapFromSnapshot {
+		reloadLevel(mapOf(joinPlayerID))
 	}
+	requestMapDataWS()
 }
 
-func clientWebsocketConnect(websocket_url string, path string, invite_code string) {
-	u := url.URL{Scheme: "ws", Host: websocket_url, Path: path}
+func dialClient(websocket_url string, path string, invite_code string) error {
+	u := websocketURL(websocket_url, path)
 	log.Printf("Connecting to %s", u.String())
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	c, _, err := websocketDialer.Dial(u.String(), nil)
 	if err != nil {
-		log.Fatal("Dial error:", err)
+		return err
 	}
 	websocket_client = c
 	if path == "/join" {
-		dialog.Alert("test")
 		response_data := make(map[string]string)
 		response_data["command"] = "registerPlayer"
 		response_data["invite_code"] = invite_code
+		// Reclaim the old player ID when reconnecting
+		if gateway_resume_token != "" {
+			response_data["player_id"] = joinPlayerID
+			response_data["resume_token"] = gateway_resume_token
+		}
 		msg, err := json.Marshal(response_data)
 		if err != nil {
 			log.Printf("Error marshalling response: %v", err)
@@ -365,17 +317,27 @@ func clientWebsocketConnect(websocket_url string, path string, invite_code strin
 
 	// Start goroutine to handle incoming messages
 	go handleWebSocketMessages()
+	return nil
 }
 func text(msg ...interface{}) string {
 	return fmt.Sprintf("%+v", msg...)
 }
 
 func handleWebSocketMessages() {
-	defer websocket_client.Close()
+	conn := websocket_client
+	defer conn.Close()
 	for {
-		_, message, err := websocket_client.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Println("WebSocket read error:", err)
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code >= 4000 {
+				log.Printf("Disconnected by server (%d): %s", closeErr.Code, closeErr.Text)
+			} else {
+				log.Println("WebSocket read error:", err)
+				if gateway_resume_token != "" {
+					go reconnectGatewayClient()
+				}
+			}
 			break
 		}
 
@@ -387,33 +349,53 @@ func handleWebSocketMessages() {
 				switch msgType {
 				case "player_id":
 					joinPlayerID = text(response["player_id"])
+					if name, ok := response["name"].(string); ok {
+						ownName = name
+					}
+					if token, ok := response["resume_token"].(string); ok {
+						gateway_resume_token = token
+					}
 					fmt.Println("registered player")
-					break
-				default:
-					log.Printf("Unknown JSON message type: %v", msgType)
-				}
-			}
-		}
-	}
-	for {
-		_, message, err := websocket_client.ReadMessage()
-		if err != nil {
-			log.Println("WebSocket read error:", err)
-			break
-		}
-
-		// Try to parse as JSON first
-		var response map[string]interface{}
-		if err := json.Unmarshal(message, &response); err == nil {
-			// Check message type to handle different JSON responses
-			if msgType, exists := response["type"]; exists {
-				switch msgType {
+					notifyRegistered(nil)
 				case "player_positions":
 					handlePlayerPositionsResponse(message)
 				case "map_data":
 					handleMapDataResponse(message)
-				//case "player_id":
-				//	joinPlayerID = text(response["player_id"])
+				case "chunk_data":
+					handleChunkDataResponse(message)
+				case "host_migration":
+					handleHostMigration(message)
+				case "throttled":
+					log.Printf("Server is throttling %v requests", response["command"])
+				case "system_message":
+					log.Printf("[System] %v", response["message"])
+					addChatLine("", text(response["message"]), true)
+				case "kicked":
+					log.Printf("Kicked from lobby: %v", response["reason"])
+					addChatLine("", "Kicked from lobby: "+text(response["reason"]), true)
+				case "chat":
+					addChatLine(text(response["name"]), text(response["text"]), false)
+				case "chat_error":
+					addChatLine("", "Chat: "+text(response["error"]), true)
+				case "crops":
+					handleCropsResponse(message)
+				case "inventory":
+					handleInventoryResponse(message)
+				case "ground_items":
+					handleGroundItemsResponse(message)
+				case "item_error":
+					addChatLine("", "Items: "+text(response["error"]), true)
+				case "map_change":
+					handleMapChangeResponse(message)
+				case "farm_error":
+					addChatLine("", "Farming: "+text(response["error"]), true)
+				case "doors":
+					handleDoorsResponse(message)
+				case "door_error":
+					addChatLine("", "Doors: "+text(response["error"]), true)
+				case "error":
+					log.Printf("Server error: %v", response["error"])
+					notifyRegistered(fmt.Errorf("%v", response["error"]))
 				default:
 					log.Printf("Unknown JSON message type: %v", msgType)
 				}
@@ -434,10 +416,29 @@ func handleWebSocketMessages() {
 	}
 }
 
+// notifyRegistered tells a waiting startGame whether the gateway accepted
+// the player.
+func notifyRegistered(err error) {
+	select {
+	case playerRegistered <- err:
+	default:
+	}
+}
+
+func waitForRegistration() error {
+	select {
+	case err := <-playerRegistered:
+		return err
+	case <-time.After(5 * time.Second):
+		return fmt.Errorf("gateway did not answer")
+	}
+}
+
 func handlePlayerPositionsResponse(message []byte) {
 	var response struct {
 		Type    string                             `json:"type"`
 		Players map[string]map[string]rl.Rectangle `json:"players"`
+		Names   map[string]string                  `json:"names"`
 	}
 	if err := json.Unmarshal(message, &response); err != nil {
 		log.Println("Error parsing player positions:", err)
@@ -445,6 +446,14 @@ func handlePlayerPositionsResponse(message []byte) {
 	}
 
 	playersMutex.Lock()
+	if host_type != "host" && host_type != "gateway" {
+		playerNames = make(map[string]string)
+	}
+	for id, name := range response.Names {
+		if id != joinPlayerID {
+			playerNames[id] = name
+		}
+	}
 	if host_type == "host" || host_type == "gateway" {
 		// Keep own player data, update others
 		ownPlayerData := joinedPlayers[joinPlayerID]
@@ -477,29 +486,6 @@ func handlePlayerPositionsResponse(message []byte) {
 	}
 	playersMutex.Unlock()
 }
-func handleMapDataResponse(message []byte) {
-	var response struct {
-		Type    string        `json:"type"`
-		Command string        `json:"command"`
-		Map     []interface{} `json:"map"` // or []string, []map[string]interface{}, etc.
-	}
-	err := json.Unmarshal(message, &response)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return
-	}
-	var stringMap []string
-	for _, item := range response.Map {
-		if str, ok := item.(string); ok {
-			stringMap = append(stringMap, str)
-		} else {
-			fmt.Printf("Warning: non-string item found: %v\n", item)
-		}
-	}
-
-	loadedMap = stringMap
-}
-
 func requestPlayerPositionsWS() {
 	if websocket_client == nil {
 		return
@@ -526,6 +512,7 @@ func sendDataRespawnWS(data RespawnData) {
 	playerData := make(map[string]string)
 	playerData["command"] = "respawn"
 	playerData["respawn"] = strconv.FormatBool(data.Respawn)
+	playerData["name"] = data.Name
 
 	jsonData, err := json.Marshal(playerData)
 	if err != nil {
@@ -537,10 +524,12 @@ func sendDataRespawnWS(data RespawnData) {
 func sendDataMovementWS(data MovementData) {
 	playerData := make(map[string]string)
 	playerData["command"] = "player_data"
-	playerData["playerUp"] = strconv.FormatBool(data.PlayerUp)
-	playerData["playerLeft"] = strconv.FormatBool(data.PlayerLeft)
-	playerData["playerDown"] = strconv.FormatBool(data.PlayerDown)
-	playerData["playerRight"] = strconv.FormatBool(data.PlayerRight)
+	playerData["actions"] = encodeActions(data.Actions)
+	playerData["dt"] = strconv.FormatFloat(float64(data.DT), 'f', 4, 32)
+	if data.Analog {
+		playerData["move_x"] = strconv.FormatFloat(float64(data.MoveX), 'f', 3, 32)
+		playerData["move_y"] = strconv.FormatFloat(float64(data.MoveY), 'f', 3, 32)
+	}
 
 	jsonData, err := json.Marshal(playerData)
 	if err != nil {
@@ -549,8 +538,15 @@ func sendDataMovementWS(data MovementData) {
 	websocket_client.WriteMessage(websocket.TextMessage, jsonData)
 }
 func requestMapDataWS() {
+	if websocket_client == nil {
+		return
+	}
 	mapRequest := make(map[string]string)
 	mapRequest["command"] = "get_map"
+	mapRequest["player_id"] = joinPlayerID
+	// The map this client has, so the server knows which chunks to send again
+	mapRequest["map"] = chunkMap
+	mapRequest["version"] = strconv.Itoa(mapVersion)
 
 	jsonData, err := json.Marshal(mapRequest)
 	if err != nil {
@@ -559,46 +555,84 @@ func requestMapDataWS() {
 	websocket_client.WriteMessage(websocket.TextMessage, jsonData)
 }
 
-func startGatewayConnection(gateway_url string) {
-	u := url.URL{Scheme: "ws", Host: gateway_url, Path: "/host"}
+// resumeGatewayHost takes over an existing gateway lobby, e.g. after this
+// client was elected as the new host.
+func resumeGatewayHost(gateway_url string, lobby_id string, host_token string) {
+	dialGateway(gateway_url, map[string]string{
+		"command":    "resumeHost",
+		"lobby_id":   lobby_id,
+		"host_token": host_token,
+	})
+}
+
+// registerHostPlayer tells the gateway which of the lobby's players is
+// this host's own, so it is not elected when this host leaves.
+func registerHostPlayer() {
+	conn := gatewayConn()
+	if conn == nil || joinPlayerID == "" {
+		return
+	}
+	msg, _ := json.Marshal(map[string]string{
+		"command":   "hostPlayer",
+		"player_id": joinPlayerID,
+	})
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error registering host player:", err)
+	}
+}
+
+func dialGateway(gateway_url string, data map[string]string) {
+	if err := dialGatewayHost(gateway_url, data); err != nil {
+		log.Fatal("Gateway connection error:", err)
+	}
+}
+
+func dialGatewayHost(gateway_url string, data map[string]string) error {
+	u := websocketURL(gateway_url, "/host")
 	log.Printf("Connecting to %s", u.String())
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	c, _, err := websocketDialer.Dial(u.String(), nil)
 	if err != nil {
-		log.Fatal("Dial error:", err)
-	}
-	websocket_gateway = c
-	data := map[string]string{
-		"command": "registerHost",
+		return err
 	}
+	conn := NewSafeConnection(c)
+	websocket_gateway.Store(conn)
 
 	// Convert map to JSON
 	message, err := json.Marshal(data)
 	if err != nil {
-		log.Fatal("Error marshaling JSON:", err)
+		return err
 	}
 
 	// Send JSON message
-	err = websocket_gateway.WriteMessage(websocket.TextMessage, message)
+	err = conn.WriteMessage(websocket.TextMessage, message)
 	if err != nil {
-		log.Fatal("Error writing message:", err)
+		return err
 	}
 
-	go gatewayConnectionHandler()
-
+	go gatewayConnectionHandler(conn)
+	return nil
 }
-func gatewayConnectionHandler() {
+func gatewayConnectionHandler(conn *SafeConnection) {
+	defer conn.Close()
 	for {
-		_, message, err := websocket_gateway.ReadMessage()
+		_, message, err := conn.conn.ReadMessage()
 		if err != nil {
+			if conn != gatewayConn() {
+				// Closed by abortStart, or replaced after a reconnect
+				break
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
+			} else if gateway_host_token != "" {
+				// The gateway may come back and keep our lobby
+				log.Println("Gateway connection lost, trying to resume lobby")
+				go reconnectGatewayHost()
 			} else {
 				log.Println("WebSocket connection closed")
 				quit()
 			}
 			break
 		}
-		defer websocket_gateway.Close()
 		var data map[string]string
 		err = json.Unmarshal(message, &data)
 		if err != nil {
@@ -610,6 +644,11 @@ func gatewayConnectionHandler() {
 		case "registerHostResponse":
 			fmt.Println("Lobby ID:", data["lobby_id"])
 			gateway_invite_code = data["lobby_id"]
+			gateway_host_token = data["host_token"]
+			select {
+			case lobbyCreated <- struct{}{}:
+			default:
+			}
 
 			// Now register the host as a player in the lobby
 			registerData := map[string]string{
@@ -617,66 +656,177 @@ func gatewayConnectionHandler() {
 				"invite_code": gateway_invite_code,
 			}
 			msg, _ := json.Marshal(registerData)
-			websocket_gateway.WriteMessage(websocket.TextMessage, msg)
+			conn.WriteMessage(websocket.TextMessage, msg)
 			fmt.Println("registered host")
-			break
+			sendWorldToGateway()
+		case "resumeHostResponse":
+			fmt.Println("Took over lobby:", data["lobby_id"])
+			registerHostPlayer()
+			sendWorldToGateway()
+		case "":
+			if data["type"] == "error" {
+				log.Printf("Gateway error: %s", data["error"])
+			}
+		case "player_data":
+			handlePlayerMovement(data, conn, broadcastGateway)
+		case "respawn":
+			handlePlayerRespawn(data, conn)
+		case "get_players":
+			handleGetPlayersWS(data, conn)
+		case "get_map":
+			handleGetMapWS(data, conn)
+		case "get_chunks":
+			handleGetChunksWS(data, conn)
+		case "chat":
+			handleChatWS(data, conn, broadcastGateway)
+		case "farm":
+			handleFarmWS(data, conn, broadcastGateway)
+		case "get_crops":
+			handleGetCropsWS(data, conn)
+		case "drop":
+			handleDropWS(data, conn, broadcastGateway)
+		case "move_item":
+			handleMoveItemWS(data, conn)
+		case "toggle_door":
+			handleToggleDoorWS(data, conn, broadcastGateway)
+		case "player_left":
+			removePlayer(data["player_id"])
 		default:
 			log.Printf("Unknown command: %s", data["command"])
 		}
 	}
-	for {
-		_, message, err := websocket_gateway.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			} else {
-				log.Println("WebSocket connection closed")
-				quit()
+}
+
+// removePlayer forgets everything the server keeps about a player that
+// disconnected or was kicked.
+func removePlayer(playerID string) {
+	forgetChatFlood(playerID)
+	forgetInventory(playerID)
+	forgetChunks(playerID)
+	playersMutex.Lock()
+	delete(joinedPlayers, playerID)
+	delete(moveClocks, playerID)
+	delete(playerNames, playerID)
+	delete(playerMaps, playerID)
+	playersMutex.Unlock()
+	log.Printf("Player %s disconnected and removed", playerID)
+}
+
+// handleHostMigration turns this client into the lobby host after the
+// gateway elected it. The world continues from the snapshot the gateway kept.
+func handleHostMigration(message []byte) {
+	var migration struct {
+		LobbyID     string                             `json:"lobby_id"`
+		HostToken   string                             `json:"host_token"`
+		PlayerID    string                             `json:"player_id"`
+		Start       string                             `json:"start"`
+		Maps        map[string][]string                `json:"maps"`
+		Portals     map[string][]sim.Portal            `json:"portals"`
+		Players     map[string]map[string]rl.Rectangle `json:"players"`
+		PlayerMaps  map[string]string                  `json:"player_maps"`
+		Names       map[string]string                  `json:"names"`
+		Crops       map[string]map[int]*sim.Crop       `json:"crops"`
+		Inventories map[string]sim.Inventory           `json:"inventories"`
+		GroundItems map[string][]*sim.GroundItem       `json:"ground_items"`
+		Doors       map[string][]int                   `json:"doors"`
+	}
+	if err := json.Unmarshal(message, &migration); err != nil {
+		log.Println("Error parsing host migration:", err)
+		return
+	}
+	log.Printf("Elected as new host of lobby %s", migration.LobbyID)
+
+	worldMutex.Lock()
+	restoreWorld(migration.Start, migration.Maps, migration.Portals)
+	now := time.Now()
+	for name, mapCrops := range migration.Crops {
+		for tile, crop := range mapCrops {
+			if l := world.Levels[name]; l != nil {
+				crop.Resume(now)
+				l.Crops[tile] = crop
 			}
-			break
 		}
-		defer websocket_gateway.Close()
-		var data map[string]string
-		err = json.Unmarshal(message, &data)
-		if err != nil {
-			log.Printf("JSON unmarshal error: %v", err)
-			continue
+	}
+	for id, inv := range migration.Inventories {
+		if len(inv) == sim.InventorySize {
+			world.Inventories[id] = inv
 		}
+	}
+	for name, items := range migration.GroundItems {
+		for _, item := range items {
+			if l := world.Levels[name]; l != nil {
+				l.GroundItems[item.ID] = item
+				world.NextGroundItem = max(world.NextGroundItem, item.ID+1)
+			}
+		}
+	}
+	for name, open := range migration.Doors {
+		for _, tile := range open {
+			if l := world.Levels[name]; l != nil {
+				l.Doors[tile] = true
+			}
+		}
+	}
+	worldMutex.Unlock()
 
-		switch data["command"] {
-		case "player_data":
-			handlePlayerMovement(data, websocket_gateway)
-		case "respawn":
-			handlePlayerRespawn(data, websocket_gateway)
-		case "get_players":
-			handleGetPlayersWS(data, websocket_gateway)
-		case "get_map":
-			handleGetMapWS(data, websocket_gateway)
-		//case "registerHostResponse":
-		//	fmt.Println("Lobby ID:", data["lobby_id"])
-		//	gateway_invite_code = data["lobby_id"]
-		//
-		//	// Now register the host as a player in the lobby
-		//	registerData := map[string]string{
-		//		"command":     "registerPlayer",
-		//		"invite_code": gateway_invite_code,
-		//	}
-		//	msg, _ := json.Marshal(registerData)
-		//	websocket_gateway.WriteMessage(websocket.TextMessage, msg)
-		default:
-			log.Printf("Unknown command: %s", data["command"])
+	playersMutex.Lock()
+	joinedPlayers = make(map[string]map[string]rl.Rectangle)
+	for id, player := range migration.Players {
+		joinedPlayers[id] = player
+	}
+	playerMaps = make(map[string]string)
+	for id, name := range migration.PlayerMaps {
+		playerMaps[id] = name
+	}
+	playerNames = make(map[string]string)
+	for id, name := range migration.Names {
+		playerNames[id] = name
+	}
+	playerNames[joinPlayerID] = ownName
+	if joinedPlayers[joinPlayerID] == nil {
+		joinedPlayers[joinPlayerID] = map[string]rl.Rectangle{
+			"playerDest": playerDest,
+			"playerSrc":  playerSrc,
 		}
 	}
+	if clientMap != "" {
+		playerMaps[joinPlayerID] = clientMap
+	}
+	playersMutex.Unlock()
+
+	mapFromSnapshot = true
+	gateway_server = server_url_ws
+	gateway_invite_code = migration.LobbyID
+	host_type = "gateway"
+
+	gateway_host_token = migration.HostToken
+	go resumeGatewayHost(gateway_server, migration.LobbyID, migration.HostToken)
 }
 
-func startServer(port string) {
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+// startServer listens on port and serves the game in the background. It
+// returns an error if the port can't be used.
+func startServer(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	// Each server gets its own mux, the menu may start one again after a
+	// failed attempt
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("Upgrade error:", err)
 			return
 		}
 		defer conn.Close()
+		connectedClients.Inc()
+		defer connectedClients.Dec()
+		conn.SetReadLimit(config.ClientReadLimit)
+		client := NewSafeConnection(conn)
+		limiter := ratelimit.NewConn(config.ClientLimits)
+		var lastThrottleNotice time.Time
 
 		log.Println("WebSocket connection established")
 		var playerID string
@@ -695,33 +845,103 @@ func startServer(port string) {
 			var data map[string]string
 			err = json.Unmarshal(message, &data)
 			if err != nil {
+				messageErrors.WithLabelValues("invalid").Inc()
 				log.Printf("JSON unmarshal error: %v", err)
+				limiter.Malformed()
+				if code := limiter.CloseCode(config.ClientLimits); code != 0 {
+					log.Printf("Disconnecting player %s: too many malformed messages", playerID)
+					closeWithReason(conn, code, "too many malformed messages")
+				}
+				continue
+			}
+			recordMessage(data["command"], len(message))
+
+			if !limiter.Allow(data["command"]) {
+				if code := limiter.CloseCode(config.ClientLimits); code != 0 {
+					log.Printf("Disconnecting player %s: rate limit exceeded", playerID)
+					closeWithReason(conn, code, "rate limit exceeded")
+					continue
+				}
+				// Tell the client at most once per second that it is throttled
+				if time.Since(lastThrottleNotice) > time.Second {
+					lastThrottleNotice = time.Now()
+					notice, _ := json.Marshal(map[string]string{
+						"type":    "throttled",
+						"command": data["command"],
+					})
+					client.WriteMessage(websocket.TextMessage, notice)
+				}
 				continue
 			}
 
 			switch data["command"] {
 			case "player_data":
 				data["player_id"] = playerID
-				handlePlayerMovement(data, conn)
+				handlePlayerMovement(data, client, broadcastWS)
 			case "respawn":
-				playerID = handlePlayerRespawn(data, conn)
+				// Players can't respawn as someone else
+				data["player_id"] = playerID
+				playerID = handlePlayerRespawn(data, client)
+				if playerID != "" {
+					registerClient(playerID, client)
+				}
 			case "get_players":
-				handleGetPlayersWS(data, conn)
+				handleGetPlayersWS(data, client)
 			case "get_map":
-				handleGetMapWS(data, conn)
+				data["player_id"] = playerID
+				handleGetMapWS(data, client)
+			case "get_chunks":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleGetChunksWS(data, client)
+			case "chat":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleChatWS(data, client, broadcastWS)
+			case "farm":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleFarmWS(data, client, broadcastWS)
+			case "get_crops":
+				handleGetCropsWS(data, client)
+			case "drop":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleDropWS(data, client, broadcastWS)
+			case "move_item":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleMoveItemWS(data, client)
+			case "toggle_door":
+				if playerID == "" {
+					continue
+				}
+				data["player_id"] = playerID
+				handleToggleDoorWS(data, client, broadcastWS)
 			default:
+				messageErrors.WithLabelValues(commandLabel(data["command"])).Inc()
 				log.Printf("Unknown command: %s", data["command"])
 			}
 		}
 
 		// Clean up player when disconnected
 		if playerID != "" {
-			playersMutex.Lock()
-			delete(joinedPlayers, playerID)
-			playersMutex.Unlock()
-			log.Printf("Player %d disconnected and removed", playerID)
+			unregisterClient(playerID, client)
+			removePlayer(playerID)
 		}
 	})
+	setupMetricsRoutes(mux)
+
 	file := "index.html"
 
 	if _, err := os.Stat(file); err == nil {
@@ -729,10 +949,11 @@ func startServer(port string) {
 		inhalt, err := os.ReadFile(file)
 		if err != nil {
 			fmt.Println("Fehler beim Lesen:", err)
-			return
+			listener.Close()
+			return err
 		}
 		//fmt.Println("Dateiinhalt:", string(inhalt))
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			//fmt.Fprint(w, []byte(inhalt))
 			w.Write([]byte(inhalt))
 		})
@@ -742,16 +963,26 @@ func startServer(port string) {
 		fmt.Println("Fehler beim Prüfen der Datei:", err)
 	}
 
-	fmt.Println("Server running on http://localhost:" + port)
-	err := http.ListenAndServe(":"+port, nil)
-	if err != nil {
-		fmt.Printf("Server error: %v\n", err)
-	}
+	server := &http.Server{Handler: mux}
+	hostServer = server
+	go func() {
+		var err error
+		if serverTLSEnabled() {
+			fmt.Println("Server running on https://localhost:" + port)
+			err = server.ServeTLS(listener, config.TLSCert, config.TLSKey)
+		} else {
+			fmt.Println("Server running on http://localhost:" + port)
+			err = server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Server error: %v\n", err)
+		}
+	}()
+	return nil
 }
 
-func handlePlayerMovement(data map[string]string, conn *websocket.Conn) {
+func handlePlayerMovement(data map[string]string, conn MessageWriter, broadcast func([]byte)) {
 	playersMutex.Lock()
-	defer playersMutex.Unlock()
 	var playerID string
 	playerID = data["player_id"]
 	//if err != nil {
@@ -759,37 +990,32 @@ func handlePlayerMovement(data map[string]string, conn *websocket.Conn) {
 	//}
 
 	if _, exists := joinedPlayers[playerID]; !exists {
-		log.Printf("Player %d not found for movement", playerID)
-		fmt.Println(joinedPlayers)
+		log.Printf("Player %s not found for movement", playerID)
+		playersMutex.Unlock()
 		return
 	}
 
 	currentRect := joinedPlayers[playerID]["playerDest"]
 	currentRectSrc := joinedPlayers[playerID]["playerSrc"]
-	currentPlayerDir := 0
-
-	if up, _ := strconv.ParseBool(data["playerUp"]); up {
-		currentRect.Y -= playerSpeed
-		currentPlayerDir = 1
-	}
-	if left, _ := strconv.ParseBool(data["playerLeft"]); left {
-		currentRect.X -= playerSpeed
-		currentPlayerDir = 2
-	}
-	if down, _ := strconv.ParseBool(data["playerDown"]); down {
-		currentRect.Y += playerSpeed
-		currentPlayerDir = 0
+	if moveClocks[playerID] == nil {
+		moveClocks[playerID] = &sim.MoveClock{}
 	}
-	if right, _ := strconv.ParseBool(data["playerRight"]); right {
-		currentRect.X += playerSpeed
-		currentPlayerDir = 3
+	claimed, _ := strconv.ParseFloat(data["dt"], 32)
+	moveX, moveY := sim.MessageMove(data["move_x"], data["move_y"], data["actions"])
+	dt := moveClocks[playerID].Allow(float32(claimed))
+	name := playerMap(playerID)
+	worldMutex.Lock()
+	if l := world.Level(name); l != nil {
+		dest, src := l.Move(sim.Rect(currentRect), sim.Rect(currentRectSrc), moveX, moveY, dt, playerFrame)
+		currentRect, currentRectSrc = rl.Rectangle(dest), rl.Rectangle(src)
 	}
-
-	currentRectSrc.Y = currentRectSrc.Height
-	currentRectSrc.X = ((currentRectSrc.Width * float32(playerFrame)) + ((currentRectSrc.Width * float32(maxFrames)) * float32(currentPlayerDir)))
+	worldMutex.Unlock()
 
 	joinedPlayers[playerID]["playerDest"] = currentRect
 	joinedPlayers[playerID]["playerSrc"] = currentRectSrc
+	// Nothing is sent while holding the lock, a slow client would hold up
+	// every other player
+	playersMutex.Unlock()
 
 	response := make(map[string]string) //fmt.Sprintf("%.0f,%.0f", currentRect.X, currentRect.Y)
 	currentXstr := fmt.Sprintf("%f", currentRect.X)
@@ -802,32 +1028,62 @@ func handlePlayerMovement(data map[string]string, conn *websocket.Conn) {
 		println("Json marshal error:", err)
 	}
 	conn.WriteMessage(websocket.TextMessage, msg)
+
+	playersMutex.Lock()
+	portal := takePortal(playerID)
+	if portal {
+		name = playerMap(playerID)
+		currentRect = joinedPlayers[playerID]["playerDest"]
+	}
+	playersMutex.Unlock()
+	if portal {
+		conn.WriteMessage(websocket.TextMessage, mapChangeMessage(playerID, name, currentRect))
+		sendMapState(playerID, name, conn)
+		return
+	}
+	if pickupItems(playerID, name, currentRect) {
+		conn.WriteMessage(websocket.TextMessage, inventoryMessage(playerID))
+		broadcast(groundItemsMessage(playerID, name, true))
+	}
 }
 
-func handlePlayerRespawn(data map[string]string, conn *websocket.Conn) string {
+func handlePlayerRespawn(data map[string]string, conn MessageWriter) string {
 	if respawn, _ := strconv.ParseBool(data["respawn"]); respawn {
 		var playerID string
 		playerID = data["player_id"] //rand.IntN(1000000)
 		//if err != "" {
 		//	fmt.Printf("Error in respawn handler: %v", err)
 		//}
+		if playerID == "" {
+			playerID = newPlayerID()
+		}
 
 		playersMutex.Lock()
 		joinedPlayers[playerID] = make(map[string]rl.Rectangle)
-		joinedPlayers[playerID]["playerDest"] = rl.NewRectangle(200, 200, 60, 60)
-		joinedPlayers[playerID]["playerSrc"] = rl.NewRectangle(0, 0, 48, 48)
+		joinedPlayers[playerID]["playerDest"] = rl.Rectangle(sim.SpawnDest)
+		joinedPlayers[playerID]["playerSrc"] = rl.Rectangle(sim.SpawnSrc)
+		name := sim.UniqueName(sim.SanitizeName(data["name"]), playerID, playerNames)
+		playerNames[playerID] = name
+		playerMaps[playerID] = world.Start
 		playersMutex.Unlock()
 
-		fmt.Printf("Player", playerID, "spawned. Total players:", len(joinedPlayers))
+		fmt.Printf("Player %s (%s) spawned. Total players: %d\n", playerID, name, len(joinedPlayers))
 
-		// Send the player ID back to the client
-		//conn.WriteMessage(websocket.TextMessage, []byte(strconv.Itoa(playerID)))
+		// Send the player ID and the name it got back to the client
+		response, _ := json.Marshal(map[string]string{
+			"type":      "player_id",
+			"player_id": playerID,
+			"name":      name,
+		})
+		conn.WriteMessage(websocket.TextMessage, response)
+		conn.WriteMessage(websocket.TextMessage, inventoryMessage(playerID))
+		sendMapState(playerID, mapOf(playerID), conn)
 		return playerID
 	}
 	return ""
 }
 
-func handleGetPlayersWS(data map[string]string, conn *websocket.Conn) {
+func handleGetPlayersWS(data map[string]string, conn MessageWriter) {
 	excludeID := ""
 	if excludeStr, exists := data["player_id"]; exists {
 		id := excludeStr
@@ -835,14 +1091,18 @@ func handleGetPlayersWS(data map[string]string, conn *websocket.Conn) {
 
 	}
 
+	// Only players on the same map are sent
 	playersMutex.RLock()
+	name := playerMap(excludeID)
 	playerList := make(map[string]map[string]rl.Rectangle)
+	names := make(map[string]string)
 	for id, player := range joinedPlayers {
-		if id != excludeID {
+		if id != excludeID && playerMap(id) == name {
 			playerList[id] = make(map[string]rl.Rectangle)
 			for key, rect := range player {
 				playerList[id][key] = rect
 			}
+			names[id] = playerNames[id]
 		}
 	}
 	playersMutex.RUnlock()
@@ -850,7 +1110,9 @@ func handleGetPlayersWS(data map[string]string, conn *websocket.Conn) {
 	// Wrap the player data in a response structure with type
 	response := map[string]interface{}{
 		"type":      "player_positions",
+		"map":       name,
 		"players":   playerList,
+		"names":     names,
 		"player_id": excludeID,
 	}
 
@@ -862,24 +1124,38 @@ func handleGetPlayersWS(data map[string]string, conn *websocket.Conn) {
 
 	conn.WriteMessage(websocket.TextMessage, jsonData)
 }
-func handleGetMapWS(data map[string]string, conn *websocket.Conn) {
-	data["type"] = "map_data"
-	response := map[string]interface{}{
-		"command":   data["command"],
-		"type":      data["type"],
-		"map":       loadedMap,
-		"player_id": data["player_id"],
-	}
-	jsonData, err := json.Marshal(response)
-	//fmt.Println(data)
-	if err != nil {
-		panic(err)
-	}
-	conn.WriteMessage(websocket.TextMessage, jsonData)
+
+// handleGetMapWS sends the map the player is on.
+func handleGetMapWS(data map[string]string, conn MessageWriter) {
+	playerID := data["player_id"]
+	name := mapOf(playerID)
+	checkChunks(playerID, name, data["map"], data["version"])
+	conn.WriteMessage(websocket.TextMessage, mapDataMessage(playerID, name))
 }
 
 func init() {
-	rl.InitWindow(screenWidth, screenHeight, "Simple Game")
+	if err := parseConfig(); errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := setupTLS(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := loadSettings(); err != nil {
+		log.Println("Using default settings:", err)
+	}
+	if err := sim.LoadItems(itemsFile); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := loadTilesets(tilesetsFile); err != nil {
+		log.Println("Tiles are not animated, sorted with players or autotiled:", err)
+	}
+
+	rl.InitWindow(settings.Width, settings.Height, "Simple Game")
 	rl.SetExitKey(0)
 	rl.SetTargetFPS(60)
 
@@ -895,15 +1171,14 @@ func init() {
 	playerSprite = rl.LoadTexture("resource/tilesets/player.png")
 
 	// Initialize rectangles
-	tileDest = rl.NewRectangle(0, 0, 16, 16)
+	tileDest = rl.NewRectangle(0, 0, sim.TileSize, sim.TileSize)
 	tileSrc = rl.NewRectangle(0, 0, 16, 16)
-	playerSrc = rl.NewRectangle(0, 0, 48, 48)
-	playerDest = rl.NewRectangle(200, 200, 60, 60)
+	playerSrc = rl.Rectangle(sim.SpawnSrc)
+	playerDest = rl.Rectangle(sim.SpawnDest)
 
 	// Initialize audio
 	rl.InitAudioDevice()
 	music = rl.LoadMusicStream("resource/music/music.mp3")
-	musicPaused = true
 	rl.PlayMusicStream(music)
 
 	// Initialize camera
@@ -911,103 +1186,215 @@ func init() {
 		rl.NewVector2(float32(screenWidth/2), float32(screenHeight/2)),
 		rl.NewVector2(float32(playerDest.X-(playerDest.Width/2)), float32(playerDest.Y-(playerDest.Height/2))),
 		0.0, 1.5)
-	start_args := os.Args
-	if len(start_args) < 2 {
-		fmt.Println("Usage: program <host|join|gateway> [port|server_url]")
-		os.Exit(1)
+	applySettings()
+	if config.Mode == "bench" {
+		runBench()
+		quit()
+		os.Exit(0)
+	}
+	if config.Mode == "" {
+		// No mode given, let the player choose in the main menu
+		menuActive = true
+		menu.prefill(config, settings)
+		return
 	}
 
-	host_type = start_args[1]
-	if host_type != "host" && host_type != "join" && host_type != "gateway" && host_type != "gatewayjoin" {
-		fmt.Println("Mode must be 'host' or 'join'")
-		os.Exit(1)
+	options := startOptions(config)
+	if options.Name == "" {
+		options.Name = settings.LastName
 	}
-	if host_type == "join" {
-		if len(start_args) < 2 {
-			fmt.Println("Please provide server URL for join mode")
+	if options.Mode == "discover" {
+		address, err := chooseDiscoveredGame()
+		if err != nil {
+			fmt.Println("Discovery failed:", err)
 			os.Exit(1)
 		}
-		server_url_ws = start_args[2]
+		options.Mode = "join"
+		options.Address = address
+	}
 
-		clientWebsocketConnect(server_url_ws, "/ws", "")
+	if err := startGame(options); err != nil {
+		fmt.Println(err)
+		fmt.Println("See -help for the modes and their settings")
+		os.Exit(1)
+	}
+	rememberStart(options)
+	loadMap()
+}
 
-		// Wait a moment for connection to establish
-		time.Sleep(100 * time.Millisecond)
+// StartOptions are what a game mode needs to start, from the command line
+// or the main menu.
+type StartOptions struct {
+	Mode    string
+	Address string // server or gateway address
+	Port    string // port to host on
+	Invite  string // gateway lobby to join
+	Name    string // display name, the server may change it
+}
 
-		data := RespawnData{Respawn: true}
-		sendDataRespawnWS(data)
+func (o StartOptions) validate() error {
+	if len([]rune(strings.TrimSpace(o.Name))) > sim.MaxNameLength {
+		return fmt.Errorf("name must be at most %d characters", sim.MaxNameLength)
 	}
-	if host_type == "gatewayjoin" {
-		if len(start_args) < 3 {
-			fmt.Println("Please provide server URL for join mode")
-			os.Exit(1)
+	switch o.Mode {
+	case "host":
+		return validatePort(o.Port)
+	case "join", "gateway":
+		return validateAddress(o.Address)
+	case "gatewayjoin":
+		if err := validateAddress(o.Address); err != nil {
+			return err
 		}
-		server_url_ws = start_args[2]
-
-		gateway_invite_code = os.Args[3]
-		clientWebsocketConnect(server_url_ws, "/join", gateway_invite_code)
+		if strings.TrimSpace(o.Invite) == "" {
+			return fmt.Errorf("please enter an invite code")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown mode %q, use host, join, gateway or gatewayjoin", o.Mode)
+}
 
-		// Wait a moment for connection to establish
-		time.Sleep(100 * time.Millisecond)
+func validatePort(port string) error {
+	if port == "" {
+		return fmt.Errorf("please enter a port")
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port must be a number from 1 to 65535")
+	}
+	return nil
+}
 
-		data := RespawnData{Respawn: true}
-		sendDataRespawnWS(data)
+// validateAddress checks a server address like localhost:8080 or
+// wss://example.com:443.
+func validateAddress(address string) error {
+	if address == "" {
+		return fmt.Errorf("please enter a server address")
 	}
-	if host_type == "gateway" {
-		if len(start_args) < 3 {
-			fmt.Println("Please provide gateway url and local server port")
-			os.Exit(1)
+	if scheme, rest, found := strings.Cut(address, "://"); found {
+		if scheme != "ws" && scheme != "wss" {
+			return fmt.Errorf("address must start with ws:// or wss://")
 		}
-		gateway_server = start_args[2]
-		go startGatewayConnection(gateway_server)
-		//dialog.Alert("to be implemented")
-		time.Sleep(100 * time.Millisecond)
-
-		clientWebsocketConnect(gateway_server, "/join", gateway_invite_code)
-
-		// Wait for WebSocket connection
-		time.Sleep(100 * time.Millisecond)
+		address = rest
+	}
+	host, port, err := net.SplitHostPort(strings.TrimSuffix(address, "/"))
+	if err != nil || host == "" {
+		return fmt.Errorf("address must look like host:port")
+	}
+	return validatePort(port)
+}
 
-		data := RespawnData{Respawn: true}
-		sendDataRespawnWS(data)
+// startGame starts the server or connects for the chosen mode. It returns
+// once this client is registered, the map is loaded by the caller.
+func startGame(options StartOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+	host_type = options.Mode
+	playerName = strings.TrimSpace(options.Name)
 
-		// Wait for player ID assignment
-		time.Sleep(100 * time.Millisecond)
+	// Forget answers from earlier attempts
+	select {
+	case <-playerRegistered:
+	default:
 	}
 
-	if host_type == "host" {
-		if len(start_args) < 3 {
-			fmt.Println("Please provide port for host mode")
-			os.Exit(1)
+	switch host_type {
+	case "join":
+		server_url_ws = options.Address
+		if err := dialClient(server_url_ws, "/ws", ""); err != nil {
+			return fmt.Errorf("could not connect to %s: %w", server_url_ws, err)
+		}
+	case "gatewayjoin":
+		server_url_ws = options.Address
+		gateway_invite_code = strings.TrimSpace(options.Invite)
+		if err := dialClient(server_url_ws, "/join", gateway_invite_code); err != nil {
+			return fmt.Errorf("could not connect to gateway %s: %w", server_url_ws, err)
+		}
+		if err := waitForRegistration(); err != nil {
+			return fmt.Errorf("could not join lobby: %w", err)
+		}
+	case "gateway":
+		if err := loadWorld(map_file); err != nil {
+			return fmt.Errorf("could not load map: %w", err)
+		}
+		gateway_server = options.Address
+		err := dialGatewayHost(gateway_server, map[string]string{
+			"command": "registerHost",
+		})
+		if err != nil {
+			return fmt.Errorf("could not connect to gateway %s: %w", gateway_server, err)
+		}
+		select {
+		case <-lobbyCreated:
+		case <-time.After(5 * time.Second):
+			abortStart()
+			return fmt.Errorf("gateway did not create a lobby")
 		}
-		server_port := start_args[2]
-
-		go startServer(server_port)
 
-		server_url_ws = "localhost:" + server_port
+		if err := dialClient(gateway_server, "/join", gateway_invite_code); err != nil {
+			abortStart()
+			return fmt.Errorf("could not join own lobby: %w", err)
+		}
+		if err := waitForRegistration(); err != nil {
+			abortStart()
+			return fmt.Errorf("could not join own lobby: %w", err)
+		}
+		registerHostPlayer()
+	case "host":
+		if err := loadWorld(map_file); err != nil {
+			return fmt.Errorf("could not load map: %w", err)
+		}
+		if err := startServer(options.Port); err != nil {
+			return fmt.Errorf("could not host on port %s: %w", options.Port, err)
+		}
 
-		// Wait for server to start
-		time.Sleep(200 * time.Millisecond)
+		server_url_ws = "localhost:" + options.Port
+		if serverTLSEnabled() {
+			server_url_ws = "wss://" + server_url_ws
+		}
+		if err := dialClient(server_url_ws, "/ws", ""); err != nil {
+			abortStart()
+			return fmt.Errorf("could not connect to own server: %w", err)
+		}
+		if config.Announce {
+			go startAnnouncer(options.Port)
+		}
+	}
 
-		clientWebsocketConnect(server_url_ws, "/ws", "")
+	// Wait for WebSocket connection
+	time.Sleep(100 * time.Millisecond)
 
-		// Wait for WebSocket connection
-		time.Sleep(100 * time.Millisecond)
+	data := RespawnData{Respawn: true, Name: playerName}
+	sendDataRespawnWS(data)
 
-		data := RespawnData{Respawn: true}
-		sendDataRespawnWS(data)
+	// Wait for player ID assignment
+	time.Sleep(100 * time.Millisecond)
+	return nil
+}
 
-		// Wait for player ID assignment
-		time.Sleep(100 * time.Millisecond)
+// abortStart closes what a failed startGame opened, so the menu can try
+// again. The gateway closes the lobby when its host disconnects.
+func abortStart() {
+	gateway_host_token = ""
+	gateway_invite_code = ""
+	if websocket_client != nil {
+		websocket_client.Close()
+		websocket_client = nil
+	}
+	if conn := websocket_gateway.Swap(nil); conn != nil {
+		conn.Close()
+	}
+	if hostServer != nil {
+		hostServer.Close()
+		hostServer = nil
 	}
-
-	loadMap()
 }
 
 func quit() {
 	if websocket_client != nil {
 		websocket_client.Close()
 	}
+	dropChunkTextures()
 	rl.UnloadTexture(grassSprite)
 	rl.UnloadTexture(fenceSprite)
 	rl.UnloadTexture(hillSprite)
@@ -1022,14 +1409,29 @@ func quit() {
 	rl.CloseWindow()
 }
 
-func main() {
-
+// enterGame switches from starting up to playing.
+func enterGame() {
 	rl.SetWindowTitle("Simple Game: " + host_type)
 	lastPlayerUpdate = time.Now()
+}
+
+func main() {
+	if !menuActive {
+		enterGame()
+	}
 
 	for running {
+		updateSettingsScreen()
+		updateChat()
+		if menuActive {
+			updateMenu()
+			drawMenu()
+			continue
+		}
 		input()
+		tickStart := time.Now()
 		update()
+		observeTick(tickStart)
 		render()
 	}
 	quit()
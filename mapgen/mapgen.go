@@ -0,0 +1,245 @@
+// Package mapgen generates maps in the game's map format: grass land with
+// lakes and hills from value noise, fenced fields of tilled soil and
+// houses, all from a seed.
+package mapgen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"main/autotile"
+)
+
+// Tile indices of the tilesets, see resource/tilesets. Edges and corners
+// of terrain and fences are picked by autotiling.
+const (
+	grassTile  = 56 // plain grass, 57 to 59 have a few flowers
+	hillTile   = 13 // top of a hill
+	waterTile  = 1  // 1 and 2 alternate
+	tilledTile = 13 // middle of a field
+	fenceTile  = 13 // a single post
+)
+
+// A house like the one in second.map, the roof on top of a wall with a door
+var (
+	houseTiles = [][]int{
+		{2, 2, 2, 2, 2},
+		{9, 9, 9, 9, 9},
+		{9, 9, 9, 9, 9},
+		{16, 16, 16, 16, 16},
+		{19, 19, 19, 19, 19},
+		{19, 19, 19, 19, 19},
+		{2, 9, 14, 14, 10},
+	}
+	houseCodes = [][]string{
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"wr", "wr", "wr", "wr", "wr"},
+		{"d", "ww", "ww", "ww", "ww"},
+	}
+)
+
+// Noise levels below which land is water and above which it is a hill
+const (
+	waterLevel = 0.35
+	hillLevel  = 0.72
+
+	// Width in tiles of the water around the map
+	shore = 3
+
+	// Tiles around the spawn that are kept free grass
+	spawnRadius = 4
+)
+
+// Options are what a map is generated from. The same options always give
+// the same map.
+type Options struct {
+	Seed int64
+	W, H int
+
+	// Tile where players spawn, the land around it is kept free
+	SpawnCol, SpawnRow int
+
+	// Rules that pick the edges and corners of the terrain, from
+	// tilesets.json. Without them the map has no edges.
+	Autotile autotile.Rules
+}
+
+// Map is a generated map, tiles are stored row by row.
+type Map struct {
+	W, H  int
+	Tiles []int
+	Codes []string
+
+	// Tiles taken by fields and houses, nothing else is placed there
+	used []bool
+}
+
+// MinSize is the smallest width and height a map can be generated with.
+const MinSize = 16
+
+// Generate returns a new map.
+func Generate(opts Options) (*Map, error) {
+	if opts.W < MinSize || opts.H < MinSize {
+		return nil, fmt.Errorf("maps must be at least %dx%d tiles", MinSize, MinSize)
+	}
+	m := &Map{
+		W:     opts.W,
+		H:     opts.H,
+		Tiles: make([]int, opts.W*opts.H),
+		Codes: make([]string, opts.W*opts.H),
+		used:  make([]bool, opts.W*opts.H),
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	m.terrain(opts, rng)
+
+	// Fields and houses stay off the spawn
+	for row := opts.SpawnRow - spawnRadius; row <= opts.SpawnRow+spawnRadius; row++ {
+		for col := opts.SpawnCol - spawnRadius; col <= opts.SpawnCol+spawnRadius; col++ {
+			if m.inside(col, row) {
+				m.used[row*m.W+col] = true
+			}
+		}
+	}
+	area := m.W * m.H
+	for i := 0; i < area/900+1; i++ {
+		m.place(rng, len(houseTiles[0]), len(houseTiles), m.house)
+	}
+	for i := 0; i < area/600+1; i++ {
+		m.place(rng, 6+rng.Intn(5), 5+rng.Intn(3), m.field)
+	}
+	opts.Autotile.Apply(m.W, m.H, m.Tiles, m.Codes)
+	return m, nil
+}
+
+// terrain fills the map with water, grass and hills.
+func (m *Map) terrain(opts Options, rng *rand.Rand) {
+	noise := newValueNoise(opts.Seed)
+	for row := 0; row < m.H; row++ {
+		for col := 0; col < m.W; col++ {
+			i := row*m.W + col
+			level := noise.fractal(float64(col)/14, float64(row)/14)
+
+			// Sink the land towards the edge of the map
+			edge := min(col, row, m.W-1-col, m.H-1-row)
+			if edge < shore*2 {
+				level -= float64(shore*2-edge) * 0.1
+			}
+			// Always land around the spawn
+			if math.Hypot(float64(col-opts.SpawnCol), float64(row-opts.SpawnRow)) <= spawnRadius+1 {
+				level = max(level, 0.5)
+				level = min(level, hillLevel-0.01)
+			}
+
+			switch {
+			case level < waterLevel:
+				m.Tiles[i] = waterTile + (col+row)%2
+				m.Codes[i] = "w"
+			case level > hillLevel:
+				m.Tiles[i] = hillTile
+				m.Codes[i] = "h"
+			default:
+				m.Tiles[i] = grassTile
+				if rng.Intn(12) == 0 {
+					m.Tiles[i] = grassTile + 1 + rng.Intn(3)
+				}
+				m.Codes[i] = "g"
+			}
+		}
+	}
+}
+
+func (m *Map) inside(col, row int) bool {
+	return col >= 0 && row >= 0 && col < m.W && row < m.H
+}
+
+// place looks for a free spot of grass for something w by h tiles big with
+// a tile of grass around it, and builds it there.
+func (m *Map) place(rng *rand.Rand, w, h int, build func(col, row, w, h int)) {
+	for attempt := 0; attempt < 50; attempt++ {
+		col := shore + 1 + rng.Intn(max(m.W-w-2*shore-1, 1))
+		row := shore + 1 + rng.Intn(max(m.H-h-2*shore-1, 1))
+		if m.free(col-1, row-1, w+2, h+2) {
+			build(col, row, w, h)
+			for y := row - 1; y <= row+h; y++ {
+				for x := col - 1; x <= col+w; x++ {
+					m.used[y*m.W+x] = true
+				}
+			}
+			return
+		}
+	}
+}
+
+// free reports whether an area is grass that nothing was placed on.
+func (m *Map) free(col, row, w, h int) bool {
+	for y := row; y < row+h; y++ {
+		for x := col; x < col+w; x++ {
+			if !m.inside(x, y) || m.used[y*m.W+x] || m.Codes[y*m.W+x] != "g" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (m *Map) set(col, row, tile int, code string) {
+	m.Tiles[row*m.W+col] = tile
+	m.Codes[row*m.W+col] = code
+}
+
+func (m *Map) house(col, row, w, h int) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m.set(col+x, row+y, houseTiles[y][x], houseCodes[y][x])
+		}
+	}
+}
+
+// field builds tilled soil with a fence around it, the fence has a gate in
+// the middle of the bottom side.
+func (m *Map) field(col, row, w, h int) {
+	right, bottom := col+w-1, row+h-1
+	for y := row; y <= bottom; y++ {
+		for x := col; x <= right; x++ {
+			switch {
+			case y == bottom && x == col+w/2:
+				m.set(x, y, grassTile, "g")
+			case x == col || x == right || y == row || y == bottom:
+				m.set(x, y, fenceTile, "f")
+			default:
+				m.set(x, y, tilledTile, "t")
+			}
+		}
+	}
+}
+
+// Fields returns the map like the fields of a map file.
+func (m *Map) Fields() []string {
+	return strings.Fields(m.String())
+}
+
+// String returns the map in the map file format.
+func (m *Map) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%03d %03d\n", m.W, m.H)
+	for row := 0; row < m.H; row++ {
+		for col := 0; col < m.W; col++ {
+			if col > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%02d", m.Tiles[row*m.W+col])
+		}
+		b.WriteByte('\n')
+	}
+	for row := 0; row < m.H; row++ {
+		b.WriteString(strings.Join(m.Codes[row*m.W:(row+1)*m.W], " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package mapgen
+
+import (
+	"slices"
+	"testing"
+
+	"main/autotile"
+	"main/sim"
+)
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	rules, err := autotile.Load("../resource/tilesets/tilesets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"smallest", Options{Seed: 1, W: MinSize, H: MinSize}},
+		{"default size", Options{Seed: 42, W: 64, H: 48, SpawnCol: 6, SpawnRow: 6}},
+		{"negative seed", Options{Seed: -7, W: 40, H: 90}},
+		{"autotiled", Options{Seed: 42, W: 64, H: 48, SpawnCol: 6, SpawnRow: 6, Autotile: rules}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := Generate(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := Generate(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.Tiles) != test.opts.W*test.opts.H || len(a.Codes) != len(a.Tiles) {
+				t.Fatalf("got %d tiles and %d codes for %dx%d", len(a.Tiles), len(a.Codes), test.opts.W, test.opts.H)
+			}
+			if !slices.Equal(a.Tiles, b.Tiles) || !slices.Equal(a.Codes, b.Codes) {
+				t.Fatal("the same options gave different maps")
+			}
+			if a.String() != b.String() {
+				t.Fatal("the same map was written differently")
+			}
+
+			other := test.opts
+			other.Seed++
+			c, err := Generate(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slices.Equal(a.Tiles, c.Tiles) && slices.Equal(a.Codes, c.Codes) {
+				t.Fatalf("seeds %d and %d gave the same map", test.opts.Seed, other.Seed)
+			}
+		})
+	}
+}
+
+// The bench mode of the game draws a generated map while the camera pans
+// across it. Drawing needs a window, so these benchmarks only walk the
+// tiles a frame touches: every tile without chunk textures, or the tiles of
+// chunks that just came into view with them.
+const (
+	benchMapSize = 256
+	benchFrames  = 120
+
+	// Part of the map the camera shows, the game window at zoom 1.5
+	viewW = 1000 / 1.5
+	viewH = 480 / 1.5
+)
+
+func benchTileMap(b *testing.B) sim.TileMap {
+	rules, err := autotile.Load("../resource/tilesets/tilesets.json")
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := Generate(Options{Seed: 1, W: benchMapSize, H: benchMapSize, Autotile: rules})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return sim.TileMap{W: m.W, H: m.H, Tiles: m.Tiles, Codes: m.Codes}
+}
+
+// panFrames calls frame with the camera's top left corner for each frame
+// of a pan across the map.
+func panFrames(frame func(x, y float32)) {
+	size := float32(benchMapSize) * sim.TileSize
+	for i := 0; i < benchFrames; i++ {
+		progress := float32(i) / benchFrames
+		frame(size*progress, size*progress)
+	}
+}
+
+func BenchmarkDrawEveryTile(b *testing.B) {
+	m := benchTileMap(b)
+	b.ResetTimer()
+	tiles := 0
+	for n := 0; n < b.N; n++ {
+		panFrames(func(x, y float32) {
+			for i := range m.Tiles {
+				if m.Tiles[i] != 0 && m.Code(i) != "" {
+					tiles++
+				}
+			}
+		})
+	}
+	b.ReportMetric(float64(tiles)/float64(b.N*benchFrames), "tiles/frame")
+}
+
+func BenchmarkDrawChunks(b *testing.B) {
+	m := benchTileMap(b)
+	b.ResetTimer()
+	tiles := 0
+	for n := 0; n < b.N; n++ {
+		cached := make(map[sim.ChunkPos]bool)
+		panFrames(func(x, y float32) {
+			first, last := sim.ChunkAt(x, y), sim.ChunkAt(x+viewW, y+viewH)
+			for cy := first.Y; cy <= last.Y; cy++ {
+				for cx := first.X; cx <= last.X; cx++ {
+					pos := sim.ChunkPos{X: cx, Y: cy}
+					col, row, w, h, ok := m.ChunkArea(pos)
+					if !ok || cached[pos] {
+						continue
+					}
+					cached[pos] = true
+					for r := row; r < row+h; r++ {
+						for c := col; c < col+w; c++ {
+							if i := r*m.W + c; m.Tiles[i] != 0 && m.Code(i) != "" {
+								tiles++
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+	b.ReportMetric(float64(tiles)/float64(b.N*benchFrames), "tiles/frame")
+}
@@ -0,0 +1,53 @@
+package mapgen
+
+import "math"
+
+// valueNoise is smooth noise from random values at whole coordinates.
+type valueNoise struct {
+	seed uint64
+}
+
+func newValueNoise(seed int64) valueNoise {
+	return valueNoise{seed: uint64(seed)}
+}
+
+// value returns a random value from 0 to 1 for a grid point.
+func (n valueNoise) value(x, y int) float64 {
+	h := n.seed ^ uint64(int64(x))*0x9e3779b97f4a7c15 ^ uint64(int64(y))*0xc2b2ae3d27d4eb4f
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return float64(h>>11) / float64(1<<53)
+}
+
+// at returns the noise at a position, from 0 to 1.
+func (n valueNoise) at(x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	ix, iy := int(x0), int(y0)
+	tx, ty := smooth(x-x0), smooth(y-y0)
+	top := lerp(n.value(ix, iy), n.value(ix+1, iy), tx)
+	bottom := lerp(n.value(ix, iy+1), n.value(ix+1, iy+1), tx)
+	return lerp(top, bottom, ty)
+}
+
+// fractal adds up three octaves of noise, from 0 to 1.
+func (n valueNoise) fractal(x, y float64) float64 {
+	sum, amplitude, total := 0.0, 1.0, 0.0
+	for octave := 0; octave < 3; octave++ {
+		sum += n.at(x, y) * amplitude
+		total += amplitude
+		x, y = x*2, y*2
+		amplitude /= 2
+	}
+	return sum / total
+}
+
+func smooth(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
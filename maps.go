@@ -0,0 +1,22 @@
+package main
+
+import (
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/sim"
+)
+
+// currentMap returns the map loaded by loadMap.
+func currentMap() sim.TileMap {
+	return sim.TileMap{W: mapW, H: mapH, Tiles: tileMap, Codes: srcMap}
+}
+
+// tileRect returns the area tile i is drawn in.
+func tileRect(m sim.TileMap, i int) rl.Rectangle {
+	return rl.Rectangle(m.TileRect(i))
+}
+
+// playerFeet returns the world position a player stands on.
+func playerFeet(dest rl.Rectangle) (float32, float32) {
+	return sim.PlayerFeet(sim.Rect(dest))
+}
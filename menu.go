@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/discovery"
+	"main/gameconfig"
+	"main/sim"
+)
+
+// Set while the main menu is shown instead of the game
+var menuActive bool
+
+type menuMode struct {
+	Mode  string
+	Label string
+	Help  string
+}
+
+var menuModes = []menuMode{
+	{"host", "Host", "Host a game on this computer."},
+	{"join", "Join", "Join a game on the LAN or the internet."},
+	{"gateway", "Gateway host", "Open a lobby on a gateway server and play in it."},
+	{"gatewayjoin", "Gateway join", "Join a lobby on a gateway server with its invite code."},
+}
+
+// textField is a single line text input of the main menu.
+type textField struct {
+	Label  string
+	Value  string
+	Max    int
+	Spaces bool
+	Bounds rl.Rectangle
+}
+
+func (f *textField) input() {
+	for c := rl.GetCharPressed(); c != 0; c = rl.GetCharPressed() {
+		f.add(rune(c))
+	}
+	if (rl.IsKeyDown(rl.KeyLeftControl) || rl.IsKeyDown(rl.KeyRightControl)) && rl.IsKeyPressed(rl.KeyV) {
+		for _, c := range rl.GetClipboardText() {
+			f.add(c)
+		}
+	}
+	if (rl.IsKeyPressed(rl.KeyBackspace) || rl.IsKeyPressedRepeat(rl.KeyBackspace)) && len(f.Value) > 0 {
+		f.Value = f.Value[:len(f.Value)-1]
+	}
+}
+
+// add appends a character, addresses and codes never contain spaces
+func (f *textField) add(c rune) {
+	if (c > ' ' || (c == ' ' && f.Spaces)) && c <= '~' && len(f.Value) < f.Max {
+		f.Value += string(c)
+	}
+}
+
+type discoveryResult struct {
+	games []discovery.Game
+	err   error
+}
+
+type mainMenu struct {
+	mode    int
+	focus   int
+	address textField
+	port    textField
+	gateway textField
+	invite  textField
+	name    textField
+
+	// Shown below the start button, e.g. why connecting failed
+	message    string
+	connecting bool
+	result     chan error
+
+	searching  bool
+	discovered chan discoveryResult
+	games      []discovery.Game
+	gameRects  []rl.Rectangle
+}
+
+var menu = mainMenu{
+	mode:       1,
+	address:    textField{Label: "Server address", Max: 128},
+	port:       textField{Label: "Port", Max: 5},
+	gateway:    textField{Label: "Gateway address", Max: 128},
+	invite:     textField{Label: "Invite code", Max: 64},
+	name:       textField{Label: "Name", Max: sim.MaxNameLength, Spaces: true},
+	result:     make(chan error, 1),
+	discovered: make(chan discoveryResult, 1),
+}
+
+// Layout of the menu
+var (
+	modeButtonY  float32 = 100
+	fieldX       float32 = 220
+	fieldY       float32 = 185
+	startButton          = rl.NewRectangle(220, 350, 180, 40)
+	searchButton         = rl.NewRectangle(660, 190, 300, 36)
+)
+
+func (m *mainMenu) fields() []*textField {
+	switch menuModes[m.mode].Mode {
+	case "host":
+		return []*textField{&m.name, &m.port}
+	case "gateway":
+		return []*textField{&m.name, &m.gateway}
+	case "gatewayjoin":
+		return []*textField{&m.name, &m.gateway, &m.invite}
+	}
+	return []*textField{&m.name, &m.address}
+}
+
+// prefill starts the menu with the configured values, or what was used
+// last time.
+func (m *mainMenu) prefill(c gameconfig.Config, s Settings) {
+	m.address.Value = firstNonEmpty(s.LastAddr, c.Addr)
+	m.port.Value = firstNonEmpty(s.LastPort, strconv.Itoa(c.Port))
+	m.gateway.Value = firstNonEmpty(s.LastGateway, c.Gateway)
+	m.invite.Value = firstNonEmpty(s.LastInvite, c.Invite)
+	m.name.Value = firstNonEmpty(c.Name, s.LastName)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (m *mainMenu) options() StartOptions {
+	options := StartOptions{
+		Mode:    menuModes[m.mode].Mode,
+		Address: m.address.Value,
+		Port:    m.port.Value,
+		Invite:  m.invite.Value,
+		Name:    m.name.Value,
+	}
+	if options.Mode == "gateway" || options.Mode == "gatewayjoin" {
+		options.Address = m.gateway.Value
+	}
+	return options
+}
+
+func modeButton(i int) rl.Rectangle {
+	return rl.NewRectangle(40+float32(i)*230, modeButtonY, 215, 40)
+}
+
+// start validates the input and connects in the background, so the menu
+// keeps drawing while it waits for the server.
+func (m *mainMenu) start() {
+	options := m.options()
+	if err := options.validate(); err != nil {
+		m.message = err.Error()
+		return
+	}
+	m.message = ""
+	m.connecting = true
+	go func() {
+		m.result <- startGame(options)
+	}()
+}
+
+func (m *mainMenu) search() {
+	m.searching = true
+	m.message = ""
+	go func() {
+		games, err := discovery.Discover(discovery.Port, discovery.Duration)
+		m.discovered <- discoveryResult{games, err}
+	}()
+}
+
+func (m *mainMenu) setMode(mode int) {
+	m.mode = (mode + len(menuModes)) % len(menuModes)
+	m.focus = 0
+	m.message = ""
+}
+
+func updateMenu() {
+	running = !rl.WindowShouldClose()
+	m := &menu
+
+	select {
+	case err := <-m.result:
+		m.connecting = false
+		if err != nil {
+			m.message = err.Error()
+			break
+		}
+		menuActive = false
+		rememberStart(m.options())
+		loadMap()
+		enterGame()
+		return
+	case found := <-m.discovered:
+		m.searching = false
+		m.games = found.games
+		if found.err != nil {
+			m.message = "LAN search failed: " + found.err.Error()
+		} else if len(found.games) == 0 {
+			m.message = "No games found on the LAN"
+		}
+	default:
+	}
+	if m.connecting || settingsOpen {
+		return
+	}
+
+	fields := m.fields()
+	if m.focus >= len(fields) {
+		m.focus = 0
+	}
+
+	// Keyboard
+	if rl.IsKeyPressed(rl.KeyUp) {
+		m.setMode(m.mode - 1)
+		return
+	}
+	if rl.IsKeyPressed(rl.KeyDown) {
+		m.setMode(m.mode + 1)
+		return
+	}
+	if rl.IsKeyPressed(rl.KeyTab) {
+		if rl.IsKeyDown(rl.KeyLeftShift) || rl.IsKeyDown(rl.KeyRightShift) {
+			m.focus = (m.focus + len(fields) - 1) % len(fields)
+		} else {
+			m.focus = (m.focus + 1) % len(fields)
+		}
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) || rl.IsKeyPressed(rl.KeyKpEnter) {
+		m.start()
+		return
+	}
+	fields[m.focus].input()
+
+	// Mouse
+	if !rl.IsMouseButtonPressed(rl.MouseButtonLeft) {
+		return
+	}
+	mouse := rl.GetMousePosition()
+	for i := range menuModes {
+		if rl.CheckCollisionPointRec(mouse, modeButton(i)) {
+			m.setMode(i)
+			return
+		}
+	}
+	for i, field := range fields {
+		if rl.CheckCollisionPointRec(mouse, field.Bounds) {
+			m.focus = i
+		}
+	}
+	if rl.CheckCollisionPointRec(mouse, startButton) {
+		m.start()
+	}
+	if menuModes[m.mode].Mode == "join" {
+		if !m.searching && rl.CheckCollisionPointRec(mouse, searchButton) {
+			m.search()
+		}
+		for i, rect := range m.gameRects {
+			if rl.CheckCollisionPointRec(mouse, rect) && i < len(m.games) {
+				m.address.Value = m.games[i].URL()
+			}
+		}
+	}
+}
+
+func drawButton(bounds rl.Rectangle, label string, active bool) {
+	color := rl.Fade(rl.White, 0.6)
+	if active {
+		color = rl.White
+	}
+	if rl.CheckCollisionPointRec(rl.GetMousePosition(), bounds) {
+		color = rl.Fade(rl.White, 0.85)
+	}
+	rl.DrawRectangleRec(bounds, color)
+	rl.DrawRectangleLinesEx(bounds, 2, rl.DarkGray)
+	width := rl.MeasureText(label, 20)
+	rl.DrawText(label, int32(bounds.X)+(int32(bounds.Width)-width)/2, int32(bounds.Y+bounds.Height/2)-10, 20, rl.DarkGray)
+}
+
+func drawMenu() {
+	m := &menu
+
+	rl.BeginDrawing()
+	rl.ClearBackground(bkgColor)
+
+	rl.DrawText("Simple Game", 40, 30, 40, rl.DarkGray)
+
+	for i, mode := range menuModes {
+		drawButton(modeButton(i), mode.Label, i == m.mode)
+	}
+	rl.DrawText(menuModes[m.mode].Help, 40, 155, 20, rl.DarkGray)
+
+	for i, field := range m.fields() {
+		field.Bounds = rl.NewRectangle(fieldX, fieldY+float32(i)*50, 400, 36)
+		rl.DrawText(field.Label, 40, int32(field.Bounds.Y)+8, 20, rl.DarkGray)
+		rl.DrawRectangleRec(field.Bounds, rl.White)
+		border := rl.Gray
+		value := field.Value
+		if i == m.focus {
+			border = rl.DarkGray
+			if int(rl.GetTime()*2)%2 == 0 {
+				value += "_"
+			}
+		}
+		rl.DrawRectangleLinesEx(field.Bounds, 2, border)
+		rl.DrawText(value, int32(field.Bounds.X)+8, int32(field.Bounds.Y)+8, 20, rl.Black)
+	}
+
+	if menuModes[m.mode].Mode == "join" {
+		label := "Search LAN"
+		if m.searching {
+			label = "Searching..."
+		}
+		drawButton(searchButton, label, !m.searching)
+		m.gameRects = m.gameRects[:0]
+		for i, game := range m.games {
+			rect := rl.NewRectangle(searchButton.X, searchButton.Y+46+float32(i)*28, searchButton.Width, 26)
+			m.gameRects = append(m.gameRects, rect)
+			if rl.CheckCollisionPointRec(rl.GetMousePosition(), rect) {
+				rl.DrawRectangleRec(rect, rl.Fade(rl.White, 0.6))
+			}
+			text := fmt.Sprintf("%s (%d players)", game.Address, game.Players)
+			rl.DrawText(text, int32(rect.X)+6, int32(rect.Y)+5, 16, rl.DarkGray)
+		}
+	}
+
+	if m.connecting {
+		drawButton(startButton, "Connecting...", false)
+	} else {
+		drawButton(startButton, "Start", true)
+	}
+	if m.message != "" {
+		rl.DrawText(m.message, 40, 405, 20, rl.Maroon)
+	}
+	rl.DrawText("Up/Down: mode   Tab: next field   Enter: start   Esc: settings", 40, 450, 16, rl.Gray)
+
+	if settingsOpen {
+		drawSettingsScreen()
+	}
+
+	rl.EndDrawing()
+}
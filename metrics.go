@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Commands that get their own label value, everything else is counted as
+// "other" so clients can't create arbitrary series.
+var knownCommands = map[string]bool{
+	"player_data": true,
+	"respawn":     true,
+	"get_players": true,
+	"get_map":     true,
+	"get_chunks":  true,
+	"chat":        true,
+	"farm":        true,
+	"get_crops":   true,
+	"drop":        true,
+	"move_item":   true,
+	"toggle_door": true,
+}
+
+var (
+	connectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "game_connected_clients",
+		Help: "Open WebSocket connections to the host server.",
+	})
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_messages_received_total",
+		Help: "Messages received by the host server.",
+	}, []string{"command"})
+	bytesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_bytes_received_total",
+		Help: "Bytes received by the host server.",
+	}, []string{"command"})
+	messageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_message_errors_total",
+		Help: "Messages the host server could not handle.",
+	}, []string{"command"})
+	tickDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "game_tick_duration_seconds",
+		Help:    "Time spent in one update of the game loop.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 12),
+	})
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "game_players",
+		Help: "Players spawned on the host server.",
+	}, func() float64 {
+		playersMutex.RLock()
+		defer playersMutex.RUnlock()
+		return float64(len(joinedPlayers))
+	})
+}
+
+func setupMetricsRoutes(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
+
+func commandLabel(command string) string {
+	if !knownCommands[command] {
+		return "other"
+	}
+	return command
+}
+
+func recordMessage(command string, size int) {
+	label := commandLabel(command)
+	messagesReceived.WithLabelValues(label).Inc()
+	bytesReceived.WithLabelValues(label).Add(float64(size))
+}
+
+func observeTick(start time.Time) {
+	tickDuration.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,7 @@
+package main
+
+// Seconds per walking animation frame, moving and standing
+const (
+	walkFrameTime float32 = 8.0 / 60
+	idleFrameTime float32 = 45.0 / 60
+)
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+)
+
+var (
+	// Name this player asked for and the one the server gave it
+	playerName string
+	ownName    string
+
+	// Display names by player ID, guarded by playersMutex
+	playerNames = make(map[string]string)
+)
+
+// newPlayerID returns a random ID for players connecting to the host
+// server directly.
+func newPlayerID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// drawNameTag draws name centered above a player. Players are drawn with
+// their origin at the bottom right of dest.
+func drawNameTag(name string, dest rl.Rectangle) {
+	if name == "" {
+		return
+	}
+	const fontSize = 10
+	width := rl.MeasureText(name, fontSize)
+	x := int32(dest.X-dest.Width/2) - width/2
+	y := int32(dest.Y-dest.Height) + 2
+	rl.DrawRectangle(x-2, y-1, width+4, fontSize+2, rl.Fade(rl.Black, 0.4))
+	rl.DrawText(name, x, y, fontSize, rl.White)
+}
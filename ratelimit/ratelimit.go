@@ -0,0 +1,135 @@
+// Package ratelimit limits how many messages a connection may send, in
+// total and per command, for the game's host server and the gateway.
+package ratelimit
+
+import "time"
+
+// Close codes sent to clients, 4000-4999 are free for application use.
+// Oversized messages are closed by websocket with CloseMessageTooBig (1009).
+const (
+	CloseAbuse     = 4003 // too many malformed messages
+	CloseThrottled = 4029 // kept exceeding the rate limit
+)
+
+// Limit allows Rate messages per second on average with bursts of up to
+// Burst messages.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Config configures rate limiting of a connection. Connection limits all
+// messages together, Commands limits single commands. A connection that got
+// MaxStrikes messages dropped or sent MaxInvalid malformed messages within
+// ten seconds gets disconnected, 0 disables that check.
+type Config struct {
+	Connection Limit            `json:"connection"`
+	Commands   map[string]Limit `json:"commands"`
+	MaxStrikes int              `json:"max_strikes"`
+	MaxInvalid int              `json:"max_invalid"`
+}
+
+// Bucket is a token bucket for one Limit.
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a bucket that starts full.
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token and reports whether there was one.
+func (b *Bucket) Allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Conn rate limits the messages of one connection, both in total and per
+// command. Dropped and malformed messages are counted as strikes, a
+// connection with too many strikes in strikeWindow gets disconnected.
+type Conn struct {
+	total    *Bucket
+	commands map[string]*Bucket
+	limits   map[string]Limit
+
+	strikes     int
+	invalid     int
+	windowStart time.Time
+}
+
+const strikeWindow = 10 * time.Second
+
+func NewConn(cfg Config) *Conn {
+	return &Conn{
+		total:       NewBucket(cfg.Connection),
+		commands:    make(map[string]*Bucket),
+		limits:      cfg.Commands,
+		windowStart: time.Now(),
+	}
+}
+
+// Allow reports whether a message with the given command may be handled.
+func (c *Conn) Allow(command string) bool {
+	now := time.Now()
+	c.resetWindow(now)
+
+	if limit, ok := c.limits[command]; ok {
+		bucket, exists := c.commands[command]
+		if !exists {
+			bucket = NewBucket(limit)
+			c.commands[command] = bucket
+		}
+		if !bucket.Allow(now) {
+			c.strikes++
+			return false
+		}
+	}
+	if !c.total.Allow(now) {
+		c.strikes++
+		return false
+	}
+	return true
+}
+
+// Malformed records a message that could not be parsed.
+func (c *Conn) Malformed() {
+	c.resetWindow(time.Now())
+	c.invalid++
+}
+
+// CloseCode returns the code to disconnect the connection with, or 0 if it
+// may stay connected.
+func (c *Conn) CloseCode(cfg Config) int {
+	if cfg.MaxInvalid > 0 && c.invalid >= cfg.MaxInvalid {
+		return CloseAbuse
+	}
+	if cfg.MaxStrikes > 0 && c.strikes >= cfg.MaxStrikes {
+		return CloseThrottled
+	}
+	return 0
+}
+
+func (c *Conn) resetWindow(now time.Time) {
+	if now.Sub(c.windowStart) > strikeWindow {
+		c.strikes = 0
+		c.invalid = 0
+		c.windowStart = now
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	type step struct {
+		after time.Duration // since the previous step
+		want  bool
+	}
+	burst := func(n int, want bool) []step {
+		steps := make([]step, n)
+		for i := range steps {
+			steps[i] = step{0, want}
+		}
+		return steps
+	}
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{"burst", Limit{Rate: 1, Burst: 3}, append(burst(3, true), step{0, false})},
+		{"refill one", Limit{Rate: 10, Burst: 2}, append(burst(2, true),
+			step{0, false},
+			step{100 * time.Millisecond, true},
+			step{0, false},
+		)},
+		{"partial refill", Limit{Rate: 2, Burst: 1}, []step{
+			{0, true},
+			{250 * time.Millisecond, false},
+			{250 * time.Millisecond, true},
+		}},
+		{"refill capped at burst", Limit{Rate: 100, Burst: 2}, append(burst(2, true),
+			step{time.Minute, true},
+			step{0, true},
+			step{0, false},
+		)},
+		{"no rate", Limit{Rate: 0, Burst: 1}, []step{
+			{0, true},
+			{time.Hour, false},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket := NewBucket(test.limit)
+			now := bucket.last
+			for i, step := range test.steps {
+				now = now.Add(step.after)
+				if got := bucket.Allow(now); got != step.want {
+					t.Fatalf("step %d: allow = %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConn(t *testing.T) {
+	limits := Config{
+		Connection: Limit{Rate: 0, Burst: 5},
+		Commands:   map[string]Limit{"chat": {Rate: 0, Burst: 2}},
+		MaxStrikes: 3,
+		MaxInvalid: 2,
+	}
+	tests := []struct {
+		name      string
+		commands  []string
+		malformed int
+		allowed   int
+		closeCode int
+	}{
+		{"within limits", []string{"chat", "chat", "get_map"}, 0, 3, 0},
+		{"command limit", []string{"chat", "chat", "chat"}, 0, 2, 0},
+		{"command limit strikes", []string{"chat", "chat", "chat", "chat", "chat"}, 0, 2, CloseThrottled},
+		{"connection limit", []string{"a", "b", "c", "d", "e", "f"}, 0, 5, 0},
+		{"dropped commands use no connection tokens", []string{"chat", "chat", "chat", "a", "b", "c", "d"}, 0, 5, 0},
+		{"malformed", nil, 1, 0, 0},
+		{"too many malformed", nil, 2, 0, CloseAbuse},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := NewConn(limits)
+			allowed := 0
+			for _, command := range test.commands {
+				if limiter.Allow(command) {
+					allowed++
+				}
+			}
+			for range test.malformed {
+				limiter.Malformed()
+			}
+			if allowed != test.allowed {
+				t.Errorf("allowed %d messages, want %d", allowed, test.allowed)
+			}
+			if code := limiter.CloseCode(limits); code != test.closeCode {
+				t.Errorf("closeCode = %d, want %d", code, test.closeCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+var (
+	// Secret from the gateway to reclaim our player ID after reconnecting
+	gateway_resume_token string
+
+	// How long to keep trying to reach a restarted gateway
+	reconnectTimeout = 2 * time.Minute
+)
+
+// retryWithBackoff calls connect until it succeeds or reconnectTimeout is
+// over, waiting longer after every failed attempt.
+func retryWithBackoff(name string, connect func() error) bool {
+	deadline := time.Now().Add(reconnectTimeout)
+	delay := time.Second
+	for time.Now().Before(deadline) {
+		time.Sleep(delay)
+		err := connect()
+		if err == nil {
+			log.Printf("%s: reconnected", name)
+			return true
+		}
+		log.Printf("%s: reconnect failed: %v", name, err)
+		if delay < 16*time.Second {
+			delay *= 2
+		}
+	}
+	return false
+}
+
+// reconnectGatewayClient registers the player at the gateway again after the
+// connection dropped. The resume token lets it keep its player ID.
+func reconnectGatewayClient() {
+	address := server_url_ws
+	if host_type == "gateway" {
+		address = gateway_server
+	}
+	ok := retryWithBackoff("player connection", func() error {
+		return dialClient(address, "/join", gateway_invite_code)
+	})
+	if !ok {
+		log.Println("Could not reach the gateway again")
+		running = false
+	}
+}
+
+// reconnectGatewayHost takes our lobby over again after the gateway
+// restarted.
+func reconnectGatewayHost() {
+	ok := retryWithBackoff("host connection", func() error {
+		return dialGatewayHost(gateway_server, map[string]string{
+			"command":    "resumeHost",
+			"lobby_id":   gateway_invite_code,
+			"host_token": gateway_host_token,
+		})
+	})
+	if !ok {
+		log.Println("Could not reach the gateway again")
+		running = false
+	}
+}
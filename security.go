@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+
+	"main/security"
+)
+
+var websocketDialer = websocket.DefaultDialer
+
+func checkOrigin(r *http.Request) bool {
+	return security.CheckOrigin(r, config.AllowedOrigins)
+}
+
+func serverTLSEnabled() bool {
+	return config.TLSCert != ""
+}
+
+// setupTLS validates the TLS settings and makes the dialer trust the CA from
+// the ca setting. A host with its own certificate trusts it too, so the
+// local client can connect to a self signed server.
+func setupTLS() error {
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+
+	var trusted []string
+	if config.CA != "" {
+		trusted = append(trusted, config.CA)
+	}
+	if serverTLSEnabled() {
+		trusted = append(trusted, config.TLSCert)
+	}
+	if len(trusted) == 0 {
+		return nil
+	}
+
+	dialer, err := security.Dialer(trusted...)
+	if err != nil {
+		return err
+	}
+	websocketDialer = dialer
+	return nil
+}
+
+// websocketURL builds the URL for a server address, which may start with
+// ws:// or wss://. Addresses without a scheme use ws://.
+func websocketURL(address string, path string) url.URL {
+	scheme := "ws"
+	if s, rest, found := strings.Cut(address, "://"); found {
+		scheme = s
+		address = rest
+	}
+	return url.URL{Scheme: scheme, Host: strings.TrimSuffix(address, "/"), Path: path}
+}
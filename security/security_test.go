@@ -0,0 +1,125 @@
+package security
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// startTLSServer serves a websocket echo on wss:// with a self signed
+// certificate and returns its URL and the certificate as a PEM file.
+func startTLSServer(t *testing.T, allowedOrigins []string) (string, string) {
+	t.Helper()
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return CheckOrigin(r, allowedOrigins) },
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		kind, message, err := conn.ReadMessage()
+		if err == nil {
+			conn.WriteMessage(kind, message)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return "wss" + strings.TrimPrefix(server.URL, "https"), certFile
+}
+
+func TestDialerSelfSigned(t *testing.T) {
+	url, certFile := startTLSServer(t, nil)
+
+	dialer, err := Dialer(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("handshake with the trusted certificate: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Fatalf("echo = %q, %v", message, err)
+	}
+
+	if conn, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		conn.Close()
+		t.Fatal("connected without trusting the self signed certificate")
+	}
+}
+
+func TestDialerBadFiles(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0o600)
+
+	for _, file := range []string{filepath.Join(t.TempDir(), "missing.pem"), empty} {
+		if _, err := Dialer(file); err == nil {
+			t.Errorf("Dialer(%s) accepted a file without certificates", file)
+		}
+	}
+}
+
+func TestCheckOriginOverTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "https://{host}", true},
+		{"other origin", nil, "https://evil.example", false},
+		{"allowed origin", []string{"https://game.example"}, "https://game.example", true},
+		{"allowed with spaces and case", []string{" HTTPS://Game.example "}, "https://game.example", true},
+		{"not in the list", []string{"https://game.example"}, "https://evil.example", false},
+		{"all allowed", []string{"*"}, "https://evil.example", true},
+		{"empty entry", []string{""}, "https://evil.example", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, certFile := startTLSServer(t, test.allowed)
+			dialer, err := Dialer(certFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := http.Header{}
+			if test.origin != "" {
+				host := strings.TrimPrefix(url, "wss://")
+				header.Set("Origin", strings.ReplaceAll(test.origin, "{host}", host))
+			}
+
+			conn, response, err := dialer.Dial(url, header)
+			if test.ok {
+				if err != nil {
+					t.Fatalf("handshake rejected: %v", err)
+				}
+				conn.Close()
+				return
+			}
+			if err == nil {
+				conn.Close()
+				t.Fatal("handshake accepted")
+			}
+			if response == nil || response.StatusCode != http.StatusForbidden {
+				t.Fatalf("handshake failed with %v, want 403 Forbidden", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/gameconfig"
+)
+
+// Settings are the player's preferences, loaded and checked by gameconfig.
+// Key and button names are the ones in keyNames and gamepadButtonNames.
+type Settings = gameconfig.Settings
+
+var (
+	settings     = gameconfig.DefaultSettings()
+	settingsFile string
+)
+
+// loadSettings reads the settings file, settings missing from it keep
+// their defaults.
+func loadSettings() error {
+	path, err := gameconfig.SettingsPath()
+	if err != nil {
+		return err
+	}
+	settingsFile = path
+	settings, err = gameconfig.LoadSettings(settingsFile, knownKey, knownButton)
+	return err
+}
+
+func knownKey(name string) bool {
+	_, ok := keyNames[name]
+	return ok
+}
+
+func knownButton(name string) bool {
+	_, ok := gamepadButtonNames[name]
+	return ok
+}
+
+func saveSettings() {
+	if settingsFile == "" {
+		return
+	}
+	if err := settings.Save(settingsFile); err != nil {
+		log.Println("Error saving settings:", err)
+	}
+}
+
+// applySettings sets up window, camera and audio from the settings. It must
+// run after the window, the audio device and the music are loaded.
+func applySettings() {
+	if rl.GetScreenWidth() != int(settings.Width) || rl.GetScreenHeight() != int(settings.Height) {
+		rl.SetWindowSize(int(settings.Width), int(settings.Height))
+	}
+	if rl.IsWindowFullscreen() != settings.Fullscreen {
+		rl.ToggleFullscreen()
+	}
+	screenWidth, screenHeight = settings.Width, settings.Height
+	cam.Offset = rl.NewVector2(float32(screenWidth/2), float32(screenHeight/2))
+	cam.Zoom = settings.Zoom
+
+	rl.SetMasterVolume(settings.MasterVolume)
+	rl.SetMusicVolume(music, settings.MusicVolume)
+	musicPaused = !settings.Music
+}
+
+// rememberStart keeps what was entered to start a game for the next run.
+func rememberStart(options StartOptions) {
+	switch options.Mode {
+	case "join":
+		settings.LastAddr = options.Address
+	case "host":
+		settings.LastPort = options.Port
+	case "gateway":
+		settings.LastGateway = options.Address
+	case "gatewayjoin":
+		settings.LastGateway = options.Address
+		settings.LastInvite = options.Invite
+	}
+	settings.LastName = options.Name
+	saveSettings()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/gameconfig"
+)
+
+var (
+	// Set while the settings screen is shown, Escape opens and closes it
+	settingsOpen bool
+	settingsRow  int
+
+	// Action waiting for a new key or button, "" when not rebinding
+	rebinding Action
+)
+
+var resolutions = [][2]int32{
+	{1000, 480},
+	{1280, 720},
+	{1600, 900},
+	{1920, 1080},
+}
+
+// Bindable actions in the order they are listed
+var bindableActions = []struct {
+	Action Action
+	Label  string
+}{
+	{ActionMoveUp, "Move up"},
+	{ActionMoveLeft, "Move left"},
+	{ActionMoveDown, "Move down"},
+	{ActionMoveRight, "Move right"},
+	{ActionInteract, "Interact"},
+	{ActionToggleMusic, "Toggle music"},
+	{ActionChat, "Chat"},
+	{ActionNextSlot, "Next slot"},
+	{ActionPrevSlot, "Previous slot"},
+	{ActionDrop, "Drop item"},
+	{ActionInventory, "Inventory"},
+}
+
+// settingRow is one line of the settings screen. Change is called with -1
+// or 1 for Left and Right, Activate for Enter.
+type settingRow struct {
+	Label    string
+	Value    func() string
+	Change   func(dir int)
+	Activate func()
+}
+
+func settingRows() []settingRow {
+	rows := []settingRow{
+		{
+			Label: "Resolution",
+			Value: func() string { return fmt.Sprintf("%dx%d", settings.Width, settings.Height) },
+			Change: func(dir int) {
+				current := 0
+				for i, r := range resolutions {
+					if r[0] == settings.Width && r[1] == settings.Height {
+						current = i
+					}
+				}
+				next := resolutions[(current+dir+len(resolutions))%len(resolutions)]
+				settings.Width, settings.Height = next[0], next[1]
+			},
+		},
+		{
+			Label:  "Fullscreen",
+			Value:  func() string { return onOff(settings.Fullscreen) },
+			Change: func(int) { settings.Fullscreen = !settings.Fullscreen },
+		},
+		{
+			Label: "Zoom",
+			Value: func() string { return fmt.Sprintf("%.2f", settings.Zoom) },
+			Change: func(dir int) {
+				settings.Zoom = clamp(settings.Zoom+0.25*float32(dir), gameconfig.MinZoom, gameconfig.MaxZoom)
+			},
+		},
+		{
+			Label:  "Master volume",
+			Value:  func() string { return fmt.Sprintf("%.0f%%", settings.MasterVolume*100) },
+			Change: func(dir int) { settings.MasterVolume = clamp(settings.MasterVolume+0.1*float32(dir), 0, 1) },
+		},
+		{
+			Label:  "Music volume",
+			Value:  func() string { return fmt.Sprintf("%.0f%%", settings.MusicVolume*100) },
+			Change: func(dir int) { settings.MusicVolume = clamp(settings.MusicVolume+0.1*float32(dir), 0, 1) },
+		},
+		{
+			Label:  "Music",
+			Value:  func() string { return onOff(settings.Music) },
+			Change: func(int) { settings.Music = !settings.Music },
+		},
+		{
+			Label: "Stick deadzone",
+			Value: func() string { return fmt.Sprintf("%.2f", settings.Deadzone) },
+			Change: func(dir int) {
+				settings.Deadzone = clamp(settings.Deadzone+0.05*float32(dir), 0, gameconfig.MaxDeadzone)
+			},
+		},
+	}
+	for _, binding := range bindableActions {
+		action := binding.Action
+		rows = append(rows, settingRow{
+			Label: binding.Label,
+			Value: func() string {
+				if rebinding == action {
+					return "press a key or button..."
+				}
+				return bindingText(action)
+			},
+			Activate: func() { rebinding = action },
+		})
+	}
+	rows = append(rows, settingRow{
+		Label:    "Back",
+		Value:    func() string { return "" },
+		Activate: func() { settingsOpen = false },
+	})
+	return rows
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func clamp(v, min, max float32) float32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// updateSettingsScreen handles the input of the settings screen and opens
+// it on Escape.
+func updateSettingsScreen() {
+	if rebinding != "" {
+		if button := pressedGamepadButton(); button != "" {
+			settings.Gamepad[rebinding] = []string{button}
+			saveSettings()
+			rebinding = ""
+			return
+		}
+		key := rl.GetKeyPressed()
+		if key == 0 {
+			return
+		}
+		if name := keyName(key); name != "" && key != rl.KeyEscape {
+			settings.Keys[rebinding] = []string{name}
+			saveSettings()
+		}
+		rebinding = ""
+		return
+	}
+
+	if chatOpen {
+		return
+	}
+	if rl.IsKeyPressed(rl.KeyEscape) {
+		settingsOpen = !settingsOpen
+		settingsRow = 0
+		return
+	}
+	if !settingsOpen {
+		return
+	}
+
+	rows := settingRows()
+	if rl.IsKeyPressed(rl.KeyUp) {
+		settingsRow = (settingsRow + len(rows) - 1) % len(rows)
+	}
+	if rl.IsKeyPressed(rl.KeyDown) {
+		settingsRow = (settingsRow + 1) % len(rows)
+	}
+	row := rows[settingsRow]
+	changed := false
+	if row.Change != nil {
+		if rl.IsKeyPressed(rl.KeyLeft) {
+			row.Change(-1)
+			changed = true
+		}
+		if rl.IsKeyPressed(rl.KeyRight) {
+			row.Change(1)
+			changed = true
+		}
+	}
+	if rl.IsKeyPressed(rl.KeyEnter) {
+		if row.Activate != nil {
+			row.Activate()
+		} else if row.Change != nil {
+			row.Change(1)
+			changed = true
+		}
+	}
+	if changed {
+		applySettings()
+		saveSettings()
+	}
+}
+
+func drawSettingsScreen() {
+	rl.DrawRectangle(0, 0, screenWidth, screenHeight, rl.Fade(rl.Black, 0.7))
+	rl.DrawText("Settings", 40, 20, 30, rl.White)
+
+	for i, row := range settingRows() {
+		y := int32(60 + i*25)
+		color := rl.LightGray
+		if i == settingsRow {
+			color = rl.White
+			rl.DrawRectangle(30, y-3, 560, 25, rl.Fade(rl.White, 0.15))
+		}
+		rl.DrawText(row.Label, 40, y, 20, color)
+		rl.DrawText(row.Value(), 300, y, 20, color)
+	}
+	rl.DrawText("Up/Down: select   Left/Right: change   Enter: rebind   Esc: close", 40, screenHeight-30, 16, rl.LightGray)
+}
@@ -0,0 +1,86 @@
+package sim
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"main/ratelimit"
+)
+
+const (
+	MaxChatLength = 200
+
+	// Same message again within this time counts as flooding
+	ChatRepeatWindow = 30 * time.Second
+)
+
+// Words masked in chat messages, also matched with the endings in
+// blockedPattern but not inside longer words like "Dickens"
+var blockedWords = []string{"fuck", "shit", "bitch", "cunt", "asshole", "bastard", "dick"}
+
+var blockedPattern = regexp.MustCompile(`(?i)\b(` + strings.Join(blockedWords, "|") + `)(s|ing|er|ed)?\b`)
+
+// Chat messages a player may send, on average and in a burst
+var ChatLimit = ratelimit.Limit{Rate: 0.5, Burst: 4}
+
+// CleanChat strips control characters and repeated spaces and cuts the
+// message to MaxChatLength.
+func CleanChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > MaxChatLength {
+		text = string(runes[:MaxChatLength])
+	}
+	return text
+}
+
+func MaskBlockedWords(text string) string {
+	return blockedPattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", len([]rune(word)))
+	})
+}
+
+// ChatFlood is what each player said last and how fast it chats.
+type ChatFlood map[string]*chatFloodState
+
+type chatFloodState struct {
+	bucket   *ratelimit.Bucket
+	lastText string
+	lastAt   time.Time
+}
+
+// Filter checks a message of a player before it is sent to everyone and
+// returns it cleaned and masked.
+func (f ChatFlood) Filter(playerID string, text string) (string, error) {
+	text = CleanChat(text)
+	if text == "" {
+		return "", fmt.Errorf("empty message")
+	}
+
+	state := f[playerID]
+	if state == nil {
+		state = &chatFloodState{bucket: ratelimit.NewBucket(ChatLimit)}
+		f[playerID] = state
+	}
+	now := time.Now()
+	if strings.EqualFold(text, state.lastText) && now.Sub(state.lastAt) < ChatRepeatWindow {
+		return "", fmt.Errorf("you just said that")
+	}
+	if !state.bucket.Allow(now) {
+		return "", fmt.Errorf("you are sending messages too fast")
+	}
+	state.lastText = text
+	state.lastAt = now
+	return MaskBlockedWords(text), nil
+}
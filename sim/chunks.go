@@ -0,0 +1,158 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Maps are sent to clients in square chunks of tiles around their camera,
+// map_data only tells them the size of the map.
+const (
+	ChunkSize = 16 // tiles
+
+	// Most chunks sent for one get_chunks
+	MaxChunksPerRequest = 16
+)
+
+type ChunkPos struct{ X, Y int }
+
+// ParseChunks reads a chunk list like "0,0;1,0", invalid entries are
+// skipped.
+func ParseChunks(list string) []ChunkPos {
+	var chunks []ChunkPos
+	for _, entry := range strings.Split(list, ";") {
+		x, y, found := strings.Cut(entry, ",")
+		if !found {
+			continue
+		}
+		cx, errX := strconv.Atoi(x)
+		cy, errY := strconv.Atoi(y)
+		if errX == nil && errY == nil {
+			chunks = append(chunks, ChunkPos{cx, cy})
+		}
+	}
+	return chunks
+}
+
+func FormatChunks(chunks []ChunkPos) string {
+	entries := make([]string, len(chunks))
+	for i, pos := range chunks {
+		entries[i] = fmt.Sprintf("%d,%d", pos.X, pos.Y)
+	}
+	return strings.Join(entries, ";")
+}
+
+// ChunkAt returns the chunk of the tile at a world position, see TileAt.
+func ChunkAt(x, y float32) ChunkPos {
+	col := int(math.Floor(float64(x/TileSize))) + 1
+	row := int(math.Floor(float64(y/TileSize))) + 1
+	return ChunkPos{
+		int(math.Floor(float64(col) / ChunkSize)),
+		int(math.Floor(float64(row) / ChunkSize)),
+	}
+}
+
+// ChunkArea returns the first tile column and row of a chunk and its size.
+// Chunks at the right and bottom edge of a map can be smaller.
+func (m TileMap) ChunkArea(pos ChunkPos) (col, row, w, h int, ok bool) {
+	col, row = pos.X*ChunkSize, pos.Y*ChunkSize
+	if pos.X < 0 || pos.Y < 0 || col >= m.W || row >= m.H {
+		return 0, 0, 0, 0, false
+	}
+	return col, row, min(ChunkSize, m.W-col), min(ChunkSize, m.H-row), true
+}
+
+// ChunkCount returns how many chunks a map has across and down.
+func (m TileMap) ChunkCount() (int, int) {
+	return (m.W + ChunkSize - 1) / ChunkSize, (m.H + ChunkSize - 1) / ChunkSize
+}
+
+// ClearChunk removes the tiles of a chunk from the map.
+func (m TileMap) ClearChunk(pos ChunkPos) {
+	col, row, w, h, ok := m.ChunkArea(pos)
+	if !ok {
+		return
+	}
+	for y := row; y < row+h; y++ {
+		for x := col; x < col+w; x++ {
+			m.Tiles[y*m.W+x] = 0
+			m.Codes[y*m.W+x] = ""
+		}
+	}
+}
+
+// ChunkResponse returns a chunk of a map, or nil if the map has no such
+// chunk.
+func (l *Level) ChunkResponse(playerID string, pos ChunkPos) map[string]interface{} {
+	col, row, w, h, ok := l.Map.ChunkArea(pos)
+	if !ok {
+		return nil
+	}
+	tiles := make([]int, 0, w*h)
+	codes := make([]string, 0, w*h)
+	for y := row; y < row+h; y++ {
+		for x := col; x < col+w; x++ {
+			i := y*l.Map.W + x
+			tile := 0
+			if i < len(l.Map.Tiles) {
+				tile = l.Map.Tiles[i]
+			}
+			tiles = append(tiles, tile)
+			codes = append(codes, l.Map.Code(i))
+		}
+	}
+	return map[string]interface{}{
+		"type":      "chunk_data",
+		"map":       l.Name,
+		"version":   l.Version,
+		"x":         pos.X,
+		"y":         pos.Y,
+		"w":         w,
+		"h":         h,
+		"tiles":     tiles,
+		"codes":     codes,
+		"player_id": playerID,
+	}
+}
+
+// SentChunks are the chunks each player was sent of the map it is on. A
+// chunk is only sent again after the client dropped it.
+type SentChunks map[string]map[ChunkPos]bool
+
+// Request returns the chunks of l in the list wanted that the player
+// wasn't sent yet. Chunks in forget were dropped by the client.
+func (s SentChunks) Request(playerID string, l *Level, wanted string, forget string) []map[string]interface{} {
+	sent := s[playerID]
+	if sent == nil {
+		sent = make(map[ChunkPos]bool)
+		s[playerID] = sent
+	}
+	for _, pos := range ParseChunks(forget) {
+		delete(sent, pos)
+	}
+	chunks := ParseChunks(wanted)
+	if len(chunks) > MaxChunksPerRequest {
+		chunks = chunks[:MaxChunksPerRequest]
+	}
+	var responses []map[string]interface{}
+	for _, pos := range chunks {
+		if sent[pos] {
+			continue
+		}
+		if response := l.ChunkResponse(playerID, pos); response != nil {
+			sent[pos] = true
+			responses = append(responses, response)
+		}
+	}
+	return responses
+}
+
+// Check forgets what a player was sent if the map it has is not the
+// current version of l.
+func (s SentChunks) Check(playerID string, l *Level, haveMap string, haveVersion string) {
+	if haveMap != l.Name || haveVersion != strconv.Itoa(l.Version) {
+		delete(s, playerID)
+	}
+}
@@ -0,0 +1,30 @@
+package sim
+
+import "fmt"
+
+// How many tiles away from a door the server lets players toggle it, more
+// than the client uses to allow for lag
+const DoorReach = 2
+
+// ToggleDoor opens or closes a door next to a player standing at dest.
+// others are the players on the map, a door can't be closed on someone
+// standing in it.
+func (l *Level) ToggleDoor(tile int, dest Rect, others []Rect) error {
+	if l.Map.Code(tile) != "d" {
+		return fmt.Errorf("no door there")
+	}
+	if feet := l.Map.TileAt(PlayerFeet(dest)); feet == -1 || l.Map.TileDistance(feet, tile) > DoorReach {
+		return fmt.Errorf("too far away")
+	}
+	if l.Doors[tile] {
+		for _, other := range others {
+			if FeetBox(other).Overlaps(l.Map.TileRect(tile)) {
+				return fmt.Errorf("someone is in the way")
+			}
+		}
+		delete(l.Doors, tile)
+	} else {
+		l.Doors[tile] = true
+	}
+	return nil
+}
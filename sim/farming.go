@@ -0,0 +1,110 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// Crops grow from seeds to a sprout, a young plant and then are ripe
+	CropStages = 4
+	CropRipe   = CropStages - 1
+
+	// How far from the tile a player may stand to farm it, in tiles
+	FarmReach = 2
+
+	// What a ripe crop gives, produce and seeds to plant again
+	HarvestYield = 2
+	HarvestSeeds = 1
+)
+
+// CropKind is a plant that can be grown on tilled soil.
+type CropKind struct {
+	Name      string
+	Seed      string        // item planted
+	Produce   string        // item harvested
+	StageTime time.Duration // watered time needed for every stage
+}
+
+var CropKinds = map[string]CropKind{
+	"wheat":  {Name: "Wheat", Seed: "wheat_seeds", Produce: "wheat", StageTime: 30 * time.Second},
+	"carrot": {Name: "Carrot", Seed: "carrot_seeds", Produce: "carrot", StageTime: 45 * time.Second},
+}
+
+// Crop is a plant on a tile. It only grows while it is watered and needs
+// water again for every stage.
+type Crop struct {
+	Kind    string `json:"kind"`
+	Stage   int    `json:"stage"`
+	Watered bool   `json:"watered"`
+
+	growth  time.Duration
+	updated time.Time
+}
+
+// Grow lets the crop grow for the time since it last grew.
+func (c *Crop) Grow(now time.Time) {
+	if c.Watered && c.Stage < CropRipe {
+		c.growth += now.Sub(c.updated)
+		if c.growth >= CropKinds[c.Kind].StageTime {
+			c.Stage++
+			c.growth = 0
+			c.Watered = false
+		}
+	}
+	c.updated = now
+}
+
+// Resume lets a crop taken over from another host grow from now on.
+func (c *Crop) Resume(now time.Time) {
+	c.updated = now
+}
+
+// Farm plants the seeds in a slot, waters or harvests the crop on a tile
+// of l for a player standing at dest, depending on what is there.
+// Harvested items that don't fit into the inventory are dropped.
+func (w *World) Farm(l *Level, playerID string, dest Rect, tile int, slot int) error {
+	if l.Map.Code(tile) != "t" {
+		return fmt.Errorf("nothing to farm there")
+	}
+	x, y := PlayerFeet(dest)
+	cx, cy := l.Map.TileCenter(tile)
+	if math.Hypot(float64(x-cx), float64(y-cy)) > FarmReach*TileSize {
+		return fmt.Errorf("too far away")
+	}
+
+	inv := w.Inventory(playerID)
+	now := time.Now()
+	crop := l.Crops[tile]
+	if crop == nil {
+		if slot < 0 || slot >= len(inv) || ItemTypes[inv[slot].Item].Crop == "" {
+			return fmt.Errorf("select seeds to plant")
+		}
+		seed := inv.Take(slot, 1)
+		l.Crops[tile] = &Crop{Kind: ItemTypes[seed.Item].Crop, updated: now}
+		return nil
+	}
+
+	crop.Grow(now)
+	if crop.Stage == CropRipe {
+		kind := CropKinds[crop.Kind]
+		w.DropItems(l, ItemStack{kind.Produce, inv.Add(kind.Produce, HarvestYield)}, cx, cy, "")
+		w.DropItems(l, ItemStack{kind.Seed, inv.Add(kind.Seed, HarvestSeeds)}, cx, cy, "")
+		delete(l.Crops, tile)
+		return nil
+	}
+	if crop.Watered {
+		return fmt.Errorf("already watered")
+	}
+	crop.Watered = true
+	return nil
+}
+
+// GrownCrops lets the crops of l grow up to now and returns them.
+func (l *Level) GrownCrops(now time.Time) map[int]*Crop {
+	for _, crop := range l.Crops {
+		crop.Grow(now)
+	}
+	return l.Crops
+}
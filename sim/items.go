@@ -0,0 +1,230 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	// Slots of an inventory, the first 9 are the hotbar
+	InventorySize = 18
+
+	DefaultMaxStack = 99
+
+	// Items closer than this to a player's feet are picked up
+	PickupRadius = 12
+)
+
+// Item is a kind of item, the kinds are loaded from resource/items.json.
+type Item struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	MaxStack int      `json:"max_stack"`
+	Icon     string   `json:"icon"`  // shape it is drawn with: seeds, bundle, root
+	Color    [3]uint8 `json:"color"` // RGB
+	Crop     string   `json:"crop"`  // crop kind planted with it, if it is a seed
+}
+
+var ItemTypes = make(map[string]Item)
+
+func LoadItems(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []Item
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for _, item := range list {
+		if item.MaxStack <= 0 {
+			item.MaxStack = DefaultMaxStack
+		}
+		ItemTypes[item.ID] = item
+	}
+	return nil
+}
+
+func MaxStack(id string) int {
+	if item, ok := ItemTypes[id]; ok {
+		return item.MaxStack
+	}
+	return DefaultMaxStack
+}
+
+func ItemName(id string) string {
+	if item, ok := ItemTypes[id]; ok {
+		return item.Name
+	}
+	return id
+}
+
+// ItemStack is a number of items of one kind in an inventory slot. Empty
+// slots have no item.
+type ItemStack struct {
+	Item  string `json:"item,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+type Inventory []ItemStack
+
+// Items every player starts with
+var StarterItems = []ItemStack{{"wheat_seeds", 5}, {"carrot_seeds", 3}}
+
+func NewInventory() Inventory {
+	inv := make(Inventory, InventorySize)
+	for _, stack := range StarterItems {
+		inv.Add(stack.Item, stack.Count)
+	}
+	return inv
+}
+
+// Add puts count items into the inventory, filling up stacks of the item
+// first. It returns how many did not fit.
+func (inv Inventory) Add(item string, count int) int {
+	limit := MaxStack(item)
+	for i := range inv {
+		if count == 0 {
+			break
+		}
+		if inv[i].Item == item && inv[i].Count < limit {
+			n := min(count, limit-inv[i].Count)
+			inv[i].Count += n
+			count -= n
+		}
+	}
+	for i := range inv {
+		if count == 0 {
+			break
+		}
+		if inv[i].Item == "" {
+			n := min(count, limit)
+			inv[i] = ItemStack{Item: item, Count: n}
+			count -= n
+		}
+	}
+	return count
+}
+
+// Take removes up to count items from a slot and returns them.
+func (inv Inventory) Take(slot int, count int) ItemStack {
+	if slot < 0 || slot >= len(inv) || inv[slot].Item == "" || count <= 0 {
+		return ItemStack{}
+	}
+	taken := ItemStack{Item: inv[slot].Item, Count: min(count, inv[slot].Count)}
+	inv[slot].Count -= taken.Count
+	if inv[slot].Count == 0 {
+		inv[slot] = ItemStack{}
+	}
+	return taken
+}
+
+// Move puts the stack of slot from onto slot to. Stacks of the same item
+// are merged, others swapped.
+func (inv Inventory) Move(from int, to int) {
+	if from < 0 || from >= len(inv) || to < 0 || to >= len(inv) || from == to {
+		return
+	}
+	if inv[from].Item == inv[to].Item && inv[to].Item != "" {
+		n := min(inv[from].Count, MaxStack(inv[to].Item)-inv[to].Count)
+		inv[to].Count += n
+		inv.Take(from, n)
+		return
+	}
+	inv[from], inv[to] = inv[to], inv[from]
+}
+
+// GroundItem is a stack of items lying on the map.
+type GroundItem struct {
+	ID    int     `json:"id"`
+	Item  string  `json:"item"`
+	Count int     `json:"count"`
+	X     float32 `json:"x"`
+	Y     float32 `json:"y"`
+
+	// Player that dropped it, it picks it up again only after walking away
+	waitFor string
+}
+
+// Inventory returns the inventory of a player, new players get the
+// starter items.
+func (w *World) Inventory(playerID string) Inventory {
+	inv := w.Inventories[playerID]
+	if inv == nil {
+		inv = NewInventory()
+		w.Inventories[playerID] = inv
+	}
+	return inv
+}
+
+// DropItems puts a stack on the ground of a map. The player that dropped
+// it, if any, doesn't pick it up right away.
+func (w *World) DropItems(l *Level, stack ItemStack, x, y float32, playerID string) {
+	if stack.Count <= 0 {
+		return
+	}
+	l.GroundItems[w.NextGroundItem] = &GroundItem{
+		ID:      w.NextGroundItem,
+		Item:    stack.Item,
+		Count:   stack.Count,
+		X:       x,
+		Y:       y,
+		waitFor: playerID,
+	}
+	w.NextGroundItem++
+}
+
+// Drop drops up to count items of a slot at the feet of a player standing
+// at dest.
+func (w *World) Drop(l *Level, playerID string, dest Rect, slot int, count int) error {
+	stack := w.Inventory(playerID).Take(slot, count)
+	if stack.Count == 0 {
+		return fmt.Errorf("nothing to drop")
+	}
+	x, y := PlayerFeet(dest)
+	w.DropItems(l, stack, x, y, playerID)
+	return nil
+}
+
+// PickupItems moves items of l near the feet of a player standing at dest
+// into its inventory and reports whether anything was picked up.
+func (w *World) PickupItems(l *Level, playerID string, dest Rect) bool {
+	x, y := PlayerFeet(dest)
+	picked := false
+	for id, item := range l.GroundItems {
+		near := math.Hypot(float64(item.X-x), float64(item.Y-y)) <= PickupRadius
+		if item.waitFor == playerID {
+			if !near {
+				item.waitFor = ""
+			}
+			continue
+		}
+		if !near {
+			continue
+		}
+		left := w.Inventory(playerID).Add(item.Item, item.Count)
+		if left == item.Count {
+			continue
+		}
+		picked = true
+		item.Count = left
+		if left == 0 {
+			delete(l.GroundItems, id)
+		}
+	}
+	return picked
+}
+
+// GroundItemList returns the items on the ground of l in the order they
+// were dropped.
+func (l *Level) GroundItemList() []GroundItem {
+	list := make([]GroundItem, 0, len(l.GroundItems))
+	for _, item := range l.GroundItems {
+		list = append(list, *item)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,164 @@
+// Package sim holds the rules of the game world that both the game's host
+// server and the gateway's own sessions run: maps and worlds, movement,
+// doors, crops, items, names and chat. It does no locking and sends no
+// messages, callers guard its state and answer the players.
+package sim
+
+import (
+	"math"
+	"strconv"
+)
+
+// Size of a map tile in world units
+const TileSize = 16
+
+// Rect is a rectangle in the world, with the same fields and JSON form as
+// rl.Rectangle so the game converts with rl.Rectangle(r) and sim.Rect(r).
+type Rect struct {
+	X      float32
+	Y      float32
+	Width  float32
+	Height float32
+}
+
+// Overlaps reports whether two rectangles overlap.
+func (r Rect) Overlaps(o Rect) bool {
+	return r.X < o.X+o.Width && r.X+r.Width > o.X &&
+		r.Y < o.Y+o.Height && r.Y+r.Height > o.Y
+}
+
+// TileMap is a parsed map. A map file has the width and height, then one
+// tileset index per tile and then one tileset code per tile, e.g. "g" for
+// grass.png.
+type TileMap struct {
+	W, H  int
+	Tiles []int
+	Codes []string
+}
+
+// ParseMap reads the fields of a map file or of map_data.
+func ParseMap(fields []string) TileMap {
+	m := TileMap{W: -1, H: -1}
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		switch {
+		case m.W == -1:
+			if err == nil {
+				m.W = n
+			}
+		case m.H == -1:
+			if err == nil {
+				m.H = n
+			}
+		case len(m.Tiles) < m.W*m.H:
+			if err == nil {
+				m.Tiles = append(m.Tiles, n)
+			}
+		default:
+			m.Codes = append(m.Codes, field)
+		}
+	}
+	return m
+}
+
+// Code returns the tileset code of tile i, or "" outside the map.
+func (m TileMap) Code(i int) string {
+	if i < 0 || i >= len(m.Codes) {
+		return ""
+	}
+	return m.Codes[i]
+}
+
+// TileAt returns the index of the tile at a world position, or -1. Tiles
+// are drawn with their origin at the bottom right like the players, so a
+// tile covers the area left of and above its grid position.
+func (m TileMap) TileAt(x, y float32) int {
+	col := int(math.Floor(float64(x/TileSize))) + 1
+	row := int(math.Floor(float64(y/TileSize))) + 1
+	if col < 0 || col >= m.W || row < 0 || row >= m.H {
+		return -1
+	}
+	return row*m.W + col
+}
+
+// TileRect returns the area tile i covers.
+func (m TileMap) TileRect(i int) Rect {
+	col := i % m.W
+	row := i / m.W
+	return Rect{X: TileSize * float32(col-1), Y: TileSize * float32(row-1), Width: TileSize, Height: TileSize}
+}
+
+// TileCenter returns the world position of the center of tile i.
+func (m TileMap) TileCenter(i int) (float32, float32) {
+	rect := m.TileRect(i)
+	return rect.X + rect.Width/2, rect.Y + rect.Height/2
+}
+
+// TileDistance returns how many tiles apart two tiles are, diagonals count
+// as one.
+func (m TileMap) TileDistance(a, b int) int {
+	dx, dy := a%m.W-b%m.W, a/m.W-b/m.W
+	return max(dx, -dx, dy, -dy)
+}
+
+// PlayerFeet returns the world position a player stands on. Players are
+// drawn with their origin at the bottom right of dest.
+func PlayerFeet(dest Rect) (float32, float32) {
+	return dest.X - dest.Width/2, dest.Y - dest.Height*0.3
+}
+
+// FeetBox returns the part of a player that collides with the map.
+func FeetBox(dest Rect) Rect {
+	x, y := PlayerFeet(dest)
+	w, h := dest.Width*0.2, dest.Height*0.1
+	return Rect{X: x - w/2, Y: y - h/2, Width: w, Height: h}
+}
+
+// Collides reports whether box overlaps a closed door.
+func (m TileMap) Collides(box Rect, open map[int]bool) bool {
+	for _, x := range []float32{box.X, box.X + box.Width} {
+		for _, y := range []float32{box.Y, box.Y + box.Height} {
+			tile := m.TileAt(x, y)
+			if m.Code(tile) == "d" && !open[tile] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MoveBlocked moves a player by dx, dy. Each axis is dropped if it would
+// run into a closed door, so players slide along them.
+func (m TileMap) MoveBlocked(dest Rect, dx, dy float32, open map[int]bool) Rect {
+	next := dest
+	next.X += dx
+	if !m.Collides(FeetBox(next), open) {
+		dest = next
+	}
+	next = dest
+	next.Y += dy
+	if !m.Collides(FeetBox(next), open) {
+		dest = next
+	}
+	return dest
+}
+
+// DoorNear returns a door within reach tiles of a position, or -1.
+func (m TileMap) DoorNear(x, y float32, reach int) int {
+	center := m.TileAt(x, y)
+	if center == -1 {
+		return -1
+	}
+	for dy := -reach; dy <= reach; dy++ {
+		for dx := -reach; dx <= reach; dx++ {
+			col, row := center%m.W+dx, center/m.W+dy
+			if col < 0 || col >= m.W || row < 0 || row >= m.H {
+				continue
+			}
+			if tile := row*m.W + col; m.Code(tile) == "d" {
+				return tile
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PlayerSpeed = 180 // units per second
+
+	// Frames of the walking animation in each row of the player sprite
+	WalkFrames = 4
+
+	// Longest step one movement message may cover, in seconds
+	MaxMoveStep float32 = 0.1
+
+	// Movement time a player can save up, covers messages that arrive in
+	// bursts after network lag
+	MaxMoveBudget float32 = 0.25
+)
+
+// Where new players appear and the first frame of the player sprite
+var (
+	SpawnDest = Rect{X: 200, Y: 200, Width: 60, Height: 60}
+	SpawnSrc  = Rect{X: 0, Y: 0, Width: 48, Height: 48}
+)
+
+// MoveStep returns how far to move in dt seconds. Diagonals are normalized
+// so they are not faster than straight movement, smaller analog input stays
+// slower.
+func MoveStep(x, y, dt float32) (float32, float32) {
+	length := float32(math.Hypot(float64(x), float64(y)))
+	if length > 1 {
+		x /= length
+		y /= length
+	}
+	return x * PlayerSpeed * dt, y * PlayerSpeed * dt
+}
+
+// MoveClock limits how much movement time the server accepts from a
+// player, so a client can't move faster by claiming long frames.
+type MoveClock struct {
+	last   time.Time
+	budget float32
+}
+
+// Allow returns the part of the claimed dt the player may move. A NaN or
+// infinite dt moves nothing, it would otherwise poison the budget.
+func (c *MoveClock) Allow(dt float32) float32 {
+	now := time.Now()
+	if !c.last.IsZero() {
+		c.budget = clamp(c.budget+float32(now.Sub(c.last).Seconds()), 0, MaxMoveBudget)
+	} else {
+		c.budget = MaxMoveStep
+	}
+	c.last = now
+
+	if !finite(float64(dt)) {
+		return 0
+	}
+	dt = clamp(dt, 0, MaxMoveStep)
+	if dt > c.budget {
+		dt = c.budget
+	}
+	c.budget -= dt
+	return dt
+}
+
+// MessageMove reads the direction of a player_data message. Analog input
+// comes as move_x and move_y, otherwise the held move actions are used.
+// NaN and infinite analog values count as missing, they would get past the
+// clamp and the collision checks.
+func MessageMove(moveX, moveY, actions string) (float32, float32) {
+	x, errX := strconv.ParseFloat(moveX, 32)
+	y, errY := strconv.ParseFloat(moveY, 32)
+	if errX == nil && errY == nil && finite(x) && finite(y) {
+		return clamp(float32(x), -1, 1), clamp(float32(y), -1, 1)
+	}
+	held := make(map[string]bool)
+	for _, action := range strings.Split(actions, ",") {
+		held[action] = true
+	}
+	var dirX, dirY float32
+	if held["up"] {
+		dirY--
+	}
+	if held["left"] {
+		dirX--
+	}
+	if held["down"] {
+		dirY++
+	}
+	if held["right"] {
+		dirX++
+	}
+	return dirX, dirY
+}
+
+// MoveDirection returns the walking animation row for a direction, the
+// axis moved on more wins. Standing keeps the current row.
+func MoveDirection(x, y float32, current int) int {
+	switch {
+	case x == 0 && y == 0:
+		return current
+	case abs(x) > abs(y) && x < 0:
+		return 2
+	case abs(x) > abs(y):
+		return 3
+	case y < 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// WalkFrame returns the part of the player sprite to draw for a frame of
+// the walking animation in a direction.
+func WalkFrame(src Rect, frame int, dir int) Rect {
+	src.Y = src.Height
+	src.X = src.Width*float32(frame) + src.Width*WalkFrames*float32(dir)
+	return src
+}
+
+// Move moves a player on l by its input for dt seconds, blocked by closed
+// doors, and shows frame of the walking animation.
+func (l *Level) Move(dest, src Rect, moveX, moveY, dt float32, frame int) (Rect, Rect) {
+	dx, dy := MoveStep(moveX, moveY, dt)
+	dest = l.Map.MoveBlocked(dest, dx, dy, l.Doors)
+	return dest, WalkFrame(src, frame, MoveDirection(moveX, moveY, 0))
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func clamp(v, min, max float32) float32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
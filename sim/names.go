@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	MaxNameLength = 16
+	DefaultName   = "Player"
+)
+
+// SanitizeName trims a requested name to printable characters and single
+// spaces.
+func SanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > MaxNameLength {
+		name = strings.TrimSpace(string(runes[:MaxNameLength]))
+	}
+	if name == "" {
+		return DefaultName
+	}
+	return name
+}
+
+// UniqueName numbers name if another player in names, by player ID,
+// already uses it.
+func UniqueName(name string, playerID string, names map[string]string) string {
+	unique := name
+	for n := 2; nameTaken(unique, playerID, names); n++ {
+		suffix := " " + strconv.Itoa(n)
+		base := []rune(name)
+		if len(base)+len(suffix) > MaxNameLength {
+			base = base[:MaxNameLength-len(suffix)]
+		}
+		unique = string(base) + suffix
+	}
+	return unique
+}
+
+func nameTaken(name string, playerID string, names map[string]string) bool {
+	for id, other := range names {
+		if id != playerID && strings.EqualFold(other, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMessageMove(t *testing.T) {
+	tests := []struct {
+		name         string
+		moveX, moveY string
+		actions      string
+		wantX, wantY float32
+	}{
+		{"analog", "0.5", "-0.25", "", 0.5, -0.25},
+		{"analog clamped", "3", "-7", "", 1, -1},
+		{"analog before actions", "0", "1", "up", 0, 1},
+		{"actions", "", "", "up,left", -1, -1},
+		{"repeated action", "", "", "down,down", 0, 1},
+		{"opposite actions", "", "", "left,right", 0, 0},
+		{"unknown action", "", "", "jump", 0, 0},
+		{"half analog", "0.5", "", "right", 1, 0},
+		{"NaN analog", "NaN", "0", "up", 0, -1},
+		{"infinite analog", "0", "-Inf", "", 0, 0},
+		{"infinite analog spelled out", "+Infinity", "0.5", "left", -1, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x, y := MessageMove(test.moveX, test.moveY, test.actions)
+			if x != test.wantX || y != test.wantY {
+				t.Fatalf("MessageMove(%q, %q, %q) = %v, %v, want %v, %v", test.moveX, test.moveY, test.actions, x, y, test.wantX, test.wantY)
+			}
+		})
+	}
+}
+
+func TestMoveClock(t *testing.T) {
+	tests := []struct {
+		name string
+		dt   float32
+		want float32
+	}{
+		{"first step", 0.01, 0.01},
+		{"negative", -1, 0},
+		{"NaN", float32(math.NaN()), 0},
+		{"infinite", float32(math.Inf(1)), 0},
+		{"negative infinite", float32(math.Inf(-1)), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var clock MoveClock
+			if got := clock.Allow(test.dt); got != test.want {
+				t.Fatalf("Allow(%v) = %v, want %v", test.dt, got, test.want)
+			}
+			// A bad dt must not break the clock for the next message
+			if got := clock.Allow(0.01); got <= 0 || got > 0.01 {
+				t.Fatalf("Allow(0.01) after %v = %v", test.dt, got)
+			}
+		})
+	}
+}
+
+func TestMoveDirection(t *testing.T) {
+	tests := []struct {
+		name    string
+		x, y    float32
+		current int
+		want    int
+	}{
+		{"standing keeps the row", 0, 0, 3, 3},
+		{"down", 0, 1, 2, 0},
+		{"up", 0, -1, 0, 1},
+		{"left", -1, 0, 0, 2},
+		{"right", 1, 0, 0, 3},
+		{"more left than down", -1, 0.5, 0, 2},
+		{"diagonal goes vertical", 1, 1, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MoveDirection(test.x, test.y, test.current); got != test.want {
+				t.Fatalf("MoveDirection(%v, %v, %d) = %d, want %d", test.x, test.y, test.current, got, test.want)
+			}
+		})
+	}
+}
+
+func TestInventory(t *testing.T) {
+	ItemTypes = map[string]Item{"stone": {ID: "stone", MaxStack: 10}}
+	t.Cleanup(func() { ItemTypes = make(map[string]Item) })
+
+	tests := []struct {
+		name  string
+		start Inventory
+		apply func(inv Inventory) int
+		want  Inventory
+		left  int
+	}{
+		{"add fills stacks first", Inventory{{}, {"stone", 8}}, func(inv Inventory) int { return inv.Add("stone", 5) },
+			Inventory{{"stone", 3}, {"stone", 10}}, 0},
+		{"add returns what did not fit", Inventory{{"stone", 9}}, func(inv Inventory) int { return inv.Add("stone", 4) },
+			Inventory{{"stone", 10}}, 3},
+		{"take part of a stack", Inventory{{"stone", 5}}, func(inv Inventory) int { return inv.Take(0, 2).Count },
+			Inventory{{"stone", 3}}, 2},
+		{"take empties the slot", Inventory{{"stone", 5}}, func(inv Inventory) int { return inv.Take(0, 9).Count },
+			Inventory{{}}, 5},
+		{"take outside the inventory", Inventory{{"stone", 5}}, func(inv Inventory) int { return inv.Take(4, 1).Count },
+			Inventory{{"stone", 5}}, 0},
+		{"move merges stacks", Inventory{{"stone", 6}, {"stone", 7}}, func(inv Inventory) int { inv.Move(0, 1); return 0 },
+			Inventory{{"stone", 3}, {"stone", 10}}, 0},
+		{"move swaps other items", Inventory{{"stone", 6}, {"wheat", 2}}, func(inv Inventory) int { inv.Move(0, 1); return 0 },
+			Inventory{{"wheat", 2}, {"stone", 6}}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inv := append(Inventory(nil), test.start...)
+			left := test.apply(inv)
+			if left != test.left {
+				t.Fatalf("got %d, want %d", left, test.left)
+			}
+			for i := range inv {
+				if inv[i] != test.want[i] {
+					t.Fatalf("inventory %v, want %v", inv, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNames(t *testing.T) {
+	names := map[string]string{"a": "Anna", "b": "Sixteen Letters!"}
+	tests := []struct {
+		name     string
+		input    string
+		playerID string
+		want     string
+	}{
+		{"plain", "Bob", "c", "Bob"},
+		{"spaces and control characters", "  Bo\tb\x00 ", "c", "Bo b"},
+		{"empty", " \n", "c", DefaultName},
+		{"too long", "A name that is far too long", "c", "A name that is f"},
+		{"taken", "anna", "c", "anna 2"},
+		{"own name", "Anna", "a", "Anna"},
+		{"taken and long", "Sixteen Letters!", "c", "Sixteen Letter 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := UniqueName(SanitizeName(test.input), test.playerID, names); got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMaskBlockedWords(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"word", "you dick", "you ****"},
+		{"any case", "SHIT happens", "**** happens"},
+		{"plural", "bastards", "********"},
+		{"-ing", "fucking hell", "******* hell"},
+		{"-er", "fucker", "******"},
+		{"-ed", "shit, fucked", "****, ******"},
+		{"inside a name", "reading Dickens and Dickinson", "reading Dickens and Dickinson"},
+		{"other ending", "Dickies", "Dickies"},
+		{"no word", "hello there", "hello there"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := MaskBlockedWords(test.text); got != test.want {
+				t.Fatalf("MaskBlockedWords(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestChatFlood(t *testing.T) {
+	flood := make(ChatFlood)
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr string
+	}{
+		{"message", "hello  there", "hello there", ""},
+		{"empty", " \t", "", "empty message"},
+		{"repeated", "Hello there", "", "you just said that"},
+		{"masked", "oh fucking", "oh *******", ""},
+		{"burst", "three", "three", ""},
+		{"burst", "four", "four", ""},
+		{"too fast", "five", "", "you are sending messages too fast"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := flood.Filter("p1", test.text)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("Filter(%q) error %v, want %q", test.text, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Fatalf("Filter(%q) = %q, %v, want %q", test.text, got, err, test.want)
+			}
+		})
+	}
+}
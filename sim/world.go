@@ -0,0 +1,188 @@
+package sim
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Portal takes players whose feet enter a tile of one map to a tile of
+// another map.
+type Portal struct {
+	Map    string `json:"map"`
+	Tile   int    `json:"tile"`
+	To     string `json:"to"`
+	ToTile int    `json:"to_tile"`
+}
+
+// WorldFile lists the maps of a world, players spawn on Start. Map names
+// are files next to the world file.
+type WorldFile struct {
+	Start   string   `json:"start"`
+	Maps    []string `json:"maps"`
+	Portals []Portal `json:"portals"`
+}
+
+// Level is one map of the world with what is on it.
+type Level struct {
+	Name    string
+	Fields  []string // like the map file
+	Map     TileMap
+	Portals []Portal
+	Version int // counts up when the map file is edited while hosting
+
+	Crops       map[int]*Crop
+	GroundItems map[int]*GroundItem
+	Doors       map[int]bool // open doors
+}
+
+func NewLevel(name string, fields []string) *Level {
+	return &Level{
+		Name:        name,
+		Fields:      fields,
+		Map:         ParseMap(fields),
+		Version:     1,
+		Crops:       make(map[int]*Crop),
+		GroundItems: make(map[int]*GroundItem),
+		Doors:       make(map[int]bool),
+	}
+}
+
+// Portal returns the portal on a tile, or nil.
+func (l *Level) Portal(tile int) *Portal {
+	for i := range l.Portals {
+		if l.Portals[i].Tile == tile {
+			return &l.Portals[i]
+		}
+	}
+	return nil
+}
+
+// LoadWorld loads the world file name from dir, or a single map file as a
+// world with just that map. readMap returns the fields of a map of the
+// world by its name. It returns the maps and the start map.
+func LoadWorld(dir string, name string, readMap func(name string) ([]string, error)) (map[string]*Level, string, error) {
+	world := WorldFile{Start: name, Maps: []string{name}}
+	if filepath.Ext(name) == ".json" {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, "", err
+		}
+		world = WorldFile{}
+		if err := json.Unmarshal(content, &world); err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if world.Start == "" && len(world.Maps) > 0 {
+		world.Start = world.Maps[0]
+	}
+
+	levels := make(map[string]*Level)
+	for _, mapName := range world.Maps {
+		fields, err := readMap(mapName)
+		if err != nil {
+			return nil, "", err
+		}
+		levels[mapName] = NewLevel(mapName, fields)
+	}
+	if levels[world.Start] == nil {
+		return nil, "", fmt.Errorf("%s: start map %q is not one of the maps", name, world.Start)
+	}
+	for _, portal := range world.Portals {
+		from, to := levels[portal.Map], levels[portal.To]
+		if from == nil || to == nil {
+			return nil, "", fmt.Errorf("%s: portal from %q to %q needs both maps", name, portal.Map, portal.To)
+		}
+		from.Portals = append(from.Portals, portal)
+	}
+	return levels, world.Start, nil
+}
+
+// World is the maps of a world with what is on them and the inventories
+// of its players.
+type World struct {
+	Levels map[string]*Level
+	Start  string
+
+	Inventories map[string]Inventory
+
+	// ID the next item dropped on the ground gets, IDs are unique in the
+	// world
+	NextGroundItem int
+}
+
+func NewWorld(levels map[string]*Level, start string) *World {
+	return &World{
+		Levels:         levels,
+		Start:          start,
+		Inventories:    make(map[string]Inventory),
+		NextGroundItem: 1,
+	}
+}
+
+// Level returns a map of the world, the start map if there is no map of
+// that name.
+func (w *World) Level(name string) *Level {
+	if l := w.Levels[name]; l != nil {
+		return l
+	}
+	return w.Levels[w.Start]
+}
+
+// TakePortal returns where a player on a map goes if it stands on a
+// portal: the map and its dest with the feet on the portal's target tile.
+func (w *World) TakePortal(name string, dest Rect) (string, Rect, bool) {
+	from := w.Level(name)
+	if from == nil {
+		return "", dest, false
+	}
+	portal := from.Portal(from.Map.TileAt(PlayerFeet(dest)))
+	if portal == nil || w.Levels[portal.To] == nil {
+		return "", dest, false
+	}
+	x, y := w.Levels[portal.To].Map.TileCenter(portal.ToTile)
+	dest.X = x + dest.Width/2
+	dest.Y = y + dest.Height*0.3
+	return portal.To, dest, true
+}
+
+// MapDataResponse returns the size and version of a map, clients ask for
+// its tiles with get_chunks.
+func (l *Level) MapDataResponse(playerID string) map[string]interface{} {
+	return map[string]interface{}{
+		"command":    "get_map",
+		"type":       "map_data",
+		"name":       l.Name,
+		"w":          l.Map.W,
+		"h":          l.Map.H,
+		"version":    l.Version,
+		"chunk_size": ChunkSize,
+		"portals":    l.Portals,
+		"clock":      time.Now().UnixMilli(),
+		"player_id":  playerID,
+	}
+}
+
+// MapChangeResponse tells a player it is now on another map.
+func MapChangeResponse(playerID string, name string, dest Rect) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "map_change",
+		"map":       name,
+		"x":         dest.X,
+		"y":         dest.Y,
+		"player_id": playerID,
+	}
+}
+
+// OpenDoors returns the open doors of the map in order.
+func (l *Level) OpenDoors() []int {
+	open := make([]int, 0, len(l.Doors))
+	for tile := range l.Doors {
+		open = append(open, tile)
+	}
+	sort.Ints(open)
+	return open
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"sort"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+
+	"main/sim"
+)
+
+var (
+	// The static tiles of each visible chunk drawn once into a texture, the
+	// tiles that still have to be drawn every frame and the tall tiles that
+	// are drawn with the players. Only used by the game loop.
+	chunkTextures = make(map[sim.ChunkPos]rl.RenderTexture2D)
+	chunkDynamic  = make(map[sim.ChunkPos][]int)
+	chunkTall     = make(map[sim.ChunkPos][]int)
+)
+
+// tileTexture returns the tileset of a tile code.
+func tileTexture(code string) rl.Texture2D {
+	switch code {
+	case "g":
+		return grassSprite
+	case "f":
+		return fenceSprite
+	case "h":
+		return hillSprite
+	case "w":
+		return waterSprite
+	case "ww":
+		return woodHouseWallsSprite
+	case "wr":
+		return woodHouseRoofSprite
+	case "t":
+		return tilledSprite
+	case "d":
+		return doorSprite
+	}
+	return rl.Texture2D{}
+}
+
+// onGrass reports whether tiles of a code are drawn over grass.
+func onGrass(code string) bool {
+	switch code {
+	case "ww", "f", "d", "wr", "t", "h":
+		return true
+	}
+	return false
+}
+
+// dynamicTile reports whether tile i of a map can look different from
+// frame to frame, so it can't be drawn into a chunk texture.
+func dynamicTile(m sim.TileMap, i int) bool {
+	code := m.Code(i)
+	return code == "d" || tileAnimation(code, m.Tiles[i]) != nil
+}
+
+// drawTileFrame draws frame (from 1) of a tileset with its top left corner
+// at x, y.
+func drawTileFrame(tex rl.Texture2D, frame int, x, y float32) {
+	columns := int(tex.Width / int32(tileSrc.Width))
+	if columns == 0 || frame < 1 {
+		return
+	}
+	src := rl.NewRectangle(tileSrc.Width*float32((frame-1)%columns), tileSrc.Height*float32((frame-1)/columns), tileSrc.Width, tileSrc.Height)
+	rl.DrawTexturePro(tex, src, rl.NewRectangle(x, y, tileDest.Width, tileDest.Height), rl.Vector2{}, 0, rl.White)
+}
+
+// drawGrassBelow draws the grass some tiles are drawn over.
+func drawGrassBelow(x, y float32) {
+	drawTileFrame(grassSprite, 5*int(grassSprite.Width/int32(tileSrc.Width))+1, x, y)
+}
+
+// tileFrame returns the frame of its tileset to draw tile i of a map with.
+func tileFrame(m sim.TileMap, i int) int {
+	code := m.Code(i)
+	if code == "d" {
+		return doorFrame(i)
+	}
+	if animation := tileAnimation(code, m.Tiles[i]); animation != nil {
+		return animation.frameAt(worldClock())
+	}
+	return m.Tiles[i]
+}
+
+// drawTile draws tile i of a map with its top left corner at x, y.
+func drawTile(m sim.TileMap, i int, x, y float32) {
+	code := m.Code(i)
+	if onGrass(code) {
+		drawGrassBelow(x, y)
+	}
+	drawTileFrame(tileTexture(code), tileFrame(m, i), x, y)
+}
+
+// cameraBounds returns the part of the world the camera shows.
+func cameraBounds() rl.Rectangle {
+	topLeft := rl.GetScreenToWorld2D(rl.NewVector2(0, 0), cam)
+	bottomRight := rl.GetScreenToWorld2D(rl.NewVector2(float32(screenWidth), float32(screenHeight)), cam)
+	return rl.NewRectangle(topLeft.X, topLeft.Y, bottomRight.X-topLeft.X, bottomRight.Y-topLeft.Y)
+}
+
+// visibleChunks returns the first and last chunk the camera shows.
+func visibleChunks() (sim.ChunkPos, sim.ChunkPos) {
+	bounds := cameraBounds()
+	return sim.ChunkAt(bounds.X, bounds.Y), sim.ChunkAt(bounds.X+bounds.Width, bounds.Y+bounds.Height)
+}
+
+// cacheChunks draws the static tiles of the visible chunks that aren't
+// cached yet into textures and drops the textures of chunks far away. It
+// has to be called outside of BeginMode2D.
+func cacheChunks() {
+	m := currentMap()
+	first, last := visibleChunks()
+	for pos := range chunkTextures {
+		if pos.X < first.X-chunkKeep || pos.X > last.X+chunkKeep || pos.Y < first.Y-chunkKeep || pos.Y > last.Y+chunkKeep {
+			dropChunkTexture(pos)
+		}
+	}
+	for y := first.Y; y <= last.Y; y++ {
+		for x := first.X; x <= last.X; x++ {
+			pos := sim.ChunkPos{x, y}
+			if _, cached := chunkTextures[pos]; cached || !loadedChunks[pos] {
+				continue
+			}
+			cacheChunk(m, pos)
+		}
+	}
+}
+
+func cacheChunk(m sim.TileMap, pos sim.ChunkPos) {
+	col, row, w, h, ok := m.ChunkArea(pos)
+	if !ok {
+		return
+	}
+	texture := rl.LoadRenderTexture(int32(w)*int32(tileDest.Width), int32(h)*int32(tileDest.Height))
+	var dynamic, tall []int
+	rl.BeginTextureMode(texture)
+	rl.ClearBackground(rl.Blank)
+	for y := row; y < row+h; y++ {
+		for x := col; x < col+w; x++ {
+			i := y*m.W + x
+			if m.Tiles[i] == 0 {
+				continue
+			}
+			tx, ty := tileDest.Width*float32(x-col), tileDest.Height*float32(y-row)
+			if standing := tallTile(m.Code(i), m.Tiles[i]); standing || dynamicTile(m, i) {
+				if standing {
+					tall = append(tall, i)
+				} else {
+					dynamic = append(dynamic, i)
+				}
+				if onGrass(m.Code(i)) {
+					drawGrassBelow(tx, ty)
+				}
+				continue
+			}
+			drawTile(m, i, tx, ty)
+		}
+	}
+	rl.EndTextureMode()
+	chunkTextures[pos] = texture
+	chunkDynamic[pos] = dynamic
+	chunkTall[pos] = tall
+}
+
+func dropChunkTexture(pos sim.ChunkPos) {
+	if texture, cached := chunkTextures[pos]; cached {
+		rl.UnloadRenderTexture(texture)
+		delete(chunkTextures, pos)
+		delete(chunkDynamic, pos)
+		delete(chunkTall, pos)
+	}
+}
+
+func dropChunkTextures() {
+	for pos := range chunkTextures {
+		dropChunkTexture(pos)
+	}
+}
+
+// drawMapTiles draws the visible chunks from their textures, with animated
+// tiles on top. Tall tiles are drawn with the players by drawDepthSorted.
+func drawMapTiles() {
+	m := currentMap()
+	first, last := visibleChunks()
+	for y := first.Y; y <= last.Y; y++ {
+		for x := first.X; x <= last.X; x++ {
+			pos := sim.ChunkPos{x, y}
+			texture, cached := chunkTextures[pos]
+			if !cached {
+				continue
+			}
+			// Tiles are drawn left of and above their grid position, see tileAt
+			col, row, w, h, _ := m.ChunkArea(pos)
+			src := rl.NewRectangle(0, 0, float32(texture.Texture.Width), -float32(texture.Texture.Height))
+			dest := rl.NewRectangle(tileDest.Width*float32(col-1), tileDest.Height*float32(row-1), tileDest.Width*float32(w), tileDest.Height*float32(h))
+			rl.DrawTexturePro(texture.Texture, src, dest, rl.Vector2{}, 0, rl.White)
+			for _, i := range chunkDynamic[pos] {
+				rect := tileRect(m, i)
+				drawTileFrame(tileTexture(m.Code(i)), tileFrame(m, i), rect.X, rect.Y)
+			}
+		}
+	}
+}
+
+// depthSprite is a tall tile or a player. They are drawn in the order of
+// where they stand, so what stands further down is in front.
+type depthSprite struct {
+	FootY float32
+	Tile  int // map tile, or -1 for a player
+	Src   rl.Rectangle
+	Dest  rl.Rectangle
+}
+
+func playerDepthSprite(src, dest rl.Rectangle) depthSprite {
+	_, y := playerFeet(dest)
+	return depthSprite{FootY: y, Tile: -1, Src: src, Dest: dest}
+}
+
+// tallSprites returns the tall tiles of the visible chunks, they stand on
+// the bottom edge of their tile.
+func tallSprites() []depthSprite {
+	m := currentMap()
+	var sprites []depthSprite
+	first, last := visibleChunks()
+	for y := first.Y; y <= last.Y; y++ {
+		for x := first.X; x <= last.X; x++ {
+			for _, i := range chunkTall[sim.ChunkPos{x, y}] {
+				rect := tileRect(m, i)
+				sprites = append(sprites, depthSprite{FootY: rect.Y + rect.Height, Tile: i})
+			}
+		}
+	}
+	return sprites
+}
+
+// drawDepthSorted draws sprites from the top of the map down. Sprites that
+// stand at the same height keep their order.
+func drawDepthSorted(sprites []depthSprite) {
+	m := currentMap()
+	sort.SliceStable(sprites, func(a, b int) bool {
+		return sprites[a].FootY < sprites[b].FootY
+	})
+	for _, sprite := range sprites {
+		if sprite.Tile >= 0 {
+			rect := tileRect(m, sprite.Tile)
+			drawTileFrame(tileTexture(m.Code(sprite.Tile)), tileFrame(m, sprite.Tile), rect.X, rect.Y)
+			continue
+		}
+		rl.DrawTexturePro(playerSprite, sprite.Src, sprite.Dest, rl.NewVector2(sprite.Dest.Width, sprite.Dest.Height), 0, rl.White)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"main/autotile"
+)
+
+const tilesetsFile = "resource/tilesets/tilesets.json"
+
+// Tileset is what tilesetsFile says about the tiles of a tileset, by tile
+// index. The autotile sections are read by the autotile package.
+type Tileset struct {
+	Animations map[int]*TileAnimation `json:"animations"`
+	Tall       []int                  `json:"tall"` // tiles that stand up, drawn in front of or behind players
+	tall       map[int]bool
+}
+
+// TileAnimation shows a tile as a list of frames of its tileset, each for
+// its duration in milliseconds.
+type TileAnimation struct {
+	Frames    []int `json:"frames"`
+	Durations []int `json:"durations"`
+	total     int
+}
+
+// frameAt returns the frame to show at a time of the world clock.
+func (a *TileAnimation) frameAt(clock int64) int {
+	t := int(clock % int64(a.total))
+	for i, duration := range a.Durations {
+		if t < duration {
+			return a.Frames[i]
+		}
+		t -= duration
+	}
+	return a.Frames[len(a.Frames)-1]
+}
+
+var (
+	// Tilesets by tile code, loaded from tilesetsFile
+	tilesets = make(map[string]*Tileset)
+
+	// Rules that pick the edge and corner tiles of terrain when maps are
+	// loaded, also from tilesetsFile
+	autotileRules autotile.Rules
+)
+
+func loadTilesets(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded map[string]*Tileset
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for code, tileset := range loaded {
+		for tile, a := range tileset.Animations {
+			if len(a.Frames) == 0 || len(a.Frames) != len(a.Durations) {
+				return fmt.Errorf("%s: animation of %s tile %d needs a duration for every frame", path, code, tile)
+			}
+			for _, duration := range a.Durations {
+				if duration <= 0 {
+					return fmt.Errorf("%s: animation of %s tile %d has a duration below 1", path, code, tile)
+				}
+				a.total += duration
+			}
+		}
+		tileset.tall = make(map[int]bool)
+		for _, tile := range tileset.Tall {
+			tileset.tall[tile] = true
+		}
+	}
+	rules, err := autotile.Load(path)
+	if err != nil {
+		return err
+	}
+	tilesets = loaded
+	autotileRules = rules
+	return nil
+}
+
+// tileAnimation returns the animation of a tile of a tileset, or nil.
+func tileAnimation(code string, tile int) *TileAnimation {
+	if tileset := tilesets[code]; tileset != nil {
+		return tileset.Animations[tile]
+	}
+	return nil
+}
+
+// tallTile reports whether a tile of a tileset stands up like a fence or a
+// wall.
+func tallTile(code string, tile int) bool {
+	tileset := tilesets[code]
+	return tileset != nil && tileset.tall[tile]
+}
+
+// How many milliseconds the server's clock is ahead of this client's,
+// set with the map data
+var clockOffset atomic.Int64
+
+// worldClock returns the time in milliseconds that all clients of a server
+// animate with.
+func worldClock() int64 {
+	return time.Now().UnixMilli() + clockOffset.Load()
+}
+
+// syncClock takes the clock the server sent. Small differences are from
+// the message delay and are ignored so animations don't jitter.
+func syncClock(server int64) {
+	offset := server - time.Now().UnixMilli()
+	if math.Abs(float64(offset-clockOffset.Load())) > 100 {
+		clockOffset.Store(offset)
+	}
+}
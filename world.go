@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	rl "github.com/gen2brain/raylib-go/raylib"
+	"github.com/gorilla/websocket"
+
+	"main/mapgen"
+	"main/sim"
+)
+
+// Hosting "random:<seed>" or "random:<seed>:<w>x<h>" generates a map
+const (
+	randomMapPrefix = "random:"
+	randomMapW      = 64
+	randomMapH      = 48
+)
+
+var (
+	// The world on the host server, guarded by worldMutex. Map files are
+	// read from worldDir.
+	world    = sim.NewWorld(make(map[string]*sim.Level), "")
+	worldDir string
+
+	// Map each player is on, guarded by playersMutex
+	playerMaps = make(map[string]string)
+
+	// Map this client is on, set with the map data
+	clientMap string
+)
+
+func readMapFile(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	remNewLines := strings.Replace(string(content), "\n", " ", -1)
+	return autotileRules.ApplyFields(strings.Fields(remNewLines)), nil
+}
+
+// loadWorld loads a world file, or a single map file as a world with just
+// that map.
+func loadWorld(file string) error {
+	if strings.HasPrefix(file, randomMapPrefix) {
+		l, err := randomLevel(file)
+		if err != nil {
+			return err
+		}
+		worldMutex.Lock()
+		world = sim.NewWorld(map[string]*sim.Level{l.Name: l}, l.Name)
+		worldDir = ""
+		worldMutex.Unlock()
+		return nil
+	}
+	dir := filepath.Dir(file)
+	loaded, start, err := sim.LoadWorld(dir, filepath.Base(file), func(name string) ([]string, error) {
+		return readMapFile(filepath.Join(dir, name))
+	})
+	if err != nil {
+		return err
+	}
+
+	worldMutex.Lock()
+	world = sim.NewWorld(loaded, start)
+	worldDir = dir
+	worldMutex.Unlock()
+	return nil
+}
+
+// randomLevel generates the map of a random world, the name is kept as the
+// map's name.
+func randomLevel(name string) (*sim.Level, error) {
+	seedText, size, sized := strings.Cut(strings.TrimPrefix(name, randomMapPrefix), ":")
+	seed, err := strconv.ParseInt(seedText, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: the seed must be a number", name)
+	}
+	w, h := randomMapW, randomMapH
+	if sized {
+		if _, err := fmt.Sscanf(size, "%dx%d", &w, &h); err != nil {
+			return nil, fmt.Errorf("%s: the size must look like 64x48", name)
+		}
+	}
+	// Keep the land free where players spawn
+	x, y := sim.PlayerFeet(sim.SpawnDest)
+	m, err := mapgen.Generate(mapgen.Options{
+		Seed:     seed,
+		W:        w,
+		H:        h,
+		SpawnCol: int(x/sim.TileSize) + 1,
+		SpawnRow: int(y/sim.TileSize) + 1,
+		Autotile: autotileRules,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return sim.NewLevel(name, m.Fields()), nil
+}
+
+// reloadLevel reads the file of a map again so edits show up while
+// hosting. The state on the map is kept. Generated maps have no file.
+func reloadLevel(name string) {
+	if worldDir == "" {
+		return
+	}
+	fields, err := readMapFile(filepath.Join(worldDir, name))
+	if err != nil {
+		log.Println("Error reloading map:", err)
+		return
+	}
+	worldMutex.Lock()
+	l := world.Levels[name]
+	changed := l != nil && !slices.Equal(l.Fields, fields)
+	if changed {
+		l.Fields = fields
+		l.Map = sim.ParseMap(fields)
+		l.Version++
+	}
+	worldMutex.Unlock()
+	if changed && host_type == "gateway" {
+		sendWorldToGateway()
+	}
+}
+
+// playerMap returns the map a player is on, the caller holds playersMutex.
+func playerMap(playerID string) string {
+	if name, exists := playerMaps[playerID]; exists {
+		return name
+	}
+	return world.Start
+}
+
+func mapOf(playerID string) string {
+	playersMutex.RLock()
+	defer playersMutex.RUnlock()
+	return playerMap(playerID)
+}
+
+// takePortal moves a player standing on a portal to the other map and
+// reports whether it did. The caller holds playersMutex.
+func takePortal(playerID string) bool {
+	worldMutex.Lock()
+	defer worldMutex.Unlock()
+	name, dest, ok := world.TakePortal(playerMap(playerID), sim.Rect(joinedPlayers[playerID]["playerDest"]))
+	if !ok {
+		return false
+	}
+	joinedPlayers[playerID]["playerDest"] = rl.Rectangle(dest)
+	playerMaps[playerID] = name
+	return true
+}
+
+// mapChangeMessage tells a player it is now on another map.
+func mapChangeMessage(playerID string, name string, dest rl.Rectangle) []byte {
+	msg, err := json.Marshal(sim.MapChangeResponse(playerID, name, sim.Rect(dest)))
+	if err != nil {
+		log.Println("Error marshalling map change:", err)
+	}
+	return msg
+}
+
+// sendMapState sends a player a map with everything on it. The tiles
+// follow as the client asks for chunks.
+func sendMapState(playerID string, name string, conn MessageWriter) {
+	forgetChunks(playerID)
+	conn.WriteMessage(websocket.TextMessage, mapDataMessage(playerID, name))
+	conn.WriteMessage(websocket.TextMessage, cropsMessage(playerID, name, false))
+	conn.WriteMessage(websocket.TextMessage, groundItemsMessage(playerID, name, false))
+	conn.WriteMessage(websocket.TextMessage, doorsMessage(playerID, name, false))
+}
+
+// mapDataMessage returns the size and version of a map, clients ask for
+// its tiles with get_chunks.
+func mapDataMessage(playerID string, name string) []byte {
+	worldMutex.Lock()
+	response := map[string]interface{}{
+		"command":   "get_map",
+		"type":      "map_data",
+		"player_id": playerID,
+	}
+	if l := world.Level(name); l != nil {
+		response = l.MapDataResponse(playerID)
+	}
+	msg, err := json.Marshal(response)
+	worldMutex.Unlock()
+	if err != nil {
+		log.Println("Error marshalling map data:", err)
+	}
+	return msg
+}
+
+// sendWorldToGateway gives the gateway all maps of the world. Clients only
+// get chunks of them, so this is what a new host continues with after a
+// host migration.
+func sendWorldToGateway() {
+	conn := gatewayConn()
+	if conn == nil {
+		return
+	}
+	worldMutex.Lock()
+	maps := make(map[string][]string)
+	portals := make(map[string][]sim.Portal)
+	for name, l := range world.Levels {
+		maps[name] = l.Fields
+		portals[name] = l.Portals
+	}
+	msg, err := json.Marshal(map[string]interface{}{
+		"command": "world",
+		"start":   world.Start,
+		"maps":    maps,
+		"portals": portals,
+	})
+	worldMutex.Unlock()
+	if err != nil {
+		log.Println("Error marshalling world:", err)
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Println("Error sending world to gateway:", err)
+	}
+}
+
+// handleMapChangeResponse moves this client to where the server put it on
+// another map. The map data and what is on the map follow.
+func handleMapChangeResponse(message []byte) {
+	var response struct {
+		Map string  `json:"map"`
+		X   float32 `json:"x"`
+		Y   float32 `json:"y"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		log.Println("Error parsing map change:", err)
+		return
+	}
+	playerDest.X = response.X
+	playerDest.Y = response.Y
+
+	playersMutex.Lock()
+	if host_type != "host" && host_type != "gateway" {
+		joinedPlayers = make(map[string]map[string]rl.Rectangle)
+	}
+	playersMutex.Unlock()
+	cropsMutex.Lock()
+	crops = make(map[int]sim.Crop)
+	cropsMutex.Unlock()
+	doorsMutex.Lock()
+	openDoors = make(map[int]bool)
+	doorsMutex.Unlock()
+	inventoryMutex.Lock()
+	groundView = nil
+	inventoryMutex.Unlock()
+}
+
+// onClientMap reports whether a message about a map is for the map this
+// client is on.
+func onClientMap(name string) bool {
+	return name == "" || clientMap == "" || name == clientMap
+}
+
+// restoreWorld builds the world from the maps the gateway kept for a host
+// migration. Maps the gateway doesn't have are read from the map directory
+// if this client has them. The caller holds worldMutex.
+func restoreWorld(start string, maps map[string][]string, portals map[string][]sim.Portal) {
+	levels := make(map[string]*sim.Level)
+	worldDir = "resource/maps"
+	for name, fields := range maps {
+		levels[name] = sim.NewLevel(name, fields)
+	}
+	for _, mapPortals := range portals {
+		for _, portal := range mapPortals {
+			if levels[portal.To] != nil || portal.To != filepath.Base(portal.To) {
+				continue
+			}
+			if fields, err := readMapFile(filepath.Join(worldDir, portal.To)); err == nil {
+				levels[portal.To] = sim.NewLevel(portal.To, fields)
+			}
+		}
+	}
+	for name, mapPortals := range portals {
+		for _, portal := range mapPortals {
+			if from := levels[name]; from != nil && levels[portal.To] != nil {
+				from.Portals = append(from.Portals, portal)
+			}
+		}
+	}
+	if levels[start] == nil {
+		for name := range levels {
+			start = name
+			break
+		}
+	}
+	world = sim.NewWorld(levels, start)
+}